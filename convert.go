@@ -0,0 +1,431 @@
+package datamapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//maxDecimalDigits decimal未声明scale时最多保留的小数位数
+const maxDecimalDigits = 64
+
+//plainDecimal 不包含指数部分的十进制数写法
+var plainDecimal = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+//convertSimple 将value转换为spec声明的简单类型
+//当数据格式错误时返回该类型的零值和错误，当value的类型无法转换时返回nil和错误
+func convertSimple(value interface{}, spec *DataSpec) (interface{}, error) {
+	switch {
+	case spec.IsNumber():
+		return toNumber(value)
+	case spec.IsString():
+		return toString(value)
+	case spec.IsBoolean():
+		return toBoolean(value)
+	case spec.IsInteger():
+		return toInteger(value)
+	case spec.IsDecimal():
+		return toDecimal(value, spec.Scale)
+	case spec.IsDateTime():
+		return toDateTime(value, spec)
+	}
+	return nil, fmt.Errorf("unsupported typeRef: %s", spec.TypeRef)
+}
+
+func toNumber(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case number:
+		return v, nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return number(0), err
+		}
+		return f, nil
+	case int64:
+		return number(v), nil
+	case bool:
+		if v {
+			return number(1), nil
+		}
+		return number(0), nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return number(0), err
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("can't parse value type: %s", typeName(value))
+}
+
+func toString(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		// 保留输入中数值的原始写法，例如"7.00"
+		return v.String(), nil
+	case number:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case time.Time:
+		return v.Format(defaultLayout), nil
+	}
+	return nil, fmt.Errorf("can't parse value type: %s", typeName(value))
+}
+
+func toBoolean(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case json.Number:
+		r, ok := new(big.Rat).SetString(v.String())
+		if !ok {
+			return false, fmt.Errorf("%q is not a number", v.String())
+		}
+		return r.Sign() != 0, nil
+	case number:
+		return v != 0, nil
+	case int64:
+		return v != 0, nil
+	case string:
+		// 支持"true"/"false"、"1"/"0"等strconv.ParseBool能够识别的格式
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return false, err
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("can't parse value type: %s", typeName(value))
+}
+
+func toInteger(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case int64:
+		return v, nil
+	case json.Number:
+		return parseInteger(v.String())
+	case number:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return int64(0), fmt.Errorf("%v is not an integer", v)
+		}
+		return int64(v), nil
+	case bool:
+		if v {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case string:
+		return parseInteger(strings.TrimSpace(v))
+	}
+	return nil, fmt.Errorf("can't parse value type: %s", typeName(value))
+}
+
+//parseInteger 解析整数，允许"7.0"、"1e3"等小数部分为0的写法，解析过程不经过float64
+func parseInteger(text string) (interface{}, error) {
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return i, nil
+	}
+	r, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return int64(0), fmt.Errorf("%q is not an integer", text)
+	}
+	if !r.IsInt() || !r.Num().IsInt64() {
+		return int64(0), fmt.Errorf("%q is not an int64 integer", text)
+	}
+	return r.Num().Int64(), nil
+}
+
+//toDecimal 将value转换为使用json.Number表示的十进制数
+//scale为nil时保留输入的精度，否则按照scale四舍五入
+func toDecimal(value interface{}, scale *int) (interface{}, error) {
+	var text string
+	switch v := value.(type) {
+	case json.Number:
+		text = v.String()
+	case string:
+		text = strings.TrimSpace(v)
+	case number:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+	case int64:
+		text = strconv.FormatInt(v, 10)
+	default:
+		return nil, fmt.Errorf("can't parse value type: %s", typeName(value))
+	}
+	r, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return decimalZero(scale), fmt.Errorf("%q is not a decimal", text)
+	}
+	if scale != nil {
+		return json.Number(r.FloatString(*scale)), nil
+	}
+	if plainDecimal.MatchString(text) {
+		return json.Number(text), nil
+	}
+	// 科学计数法等写法转换为普通的小数写法
+	return json.Number(r.FloatString(decimalDigits(r))), nil
+}
+
+func decimalZero(scale *int) json.Number {
+	if scale == nil {
+		return "0"
+	}
+	return json.Number(new(big.Rat).FloatString(*scale))
+}
+
+//decimalDigits 计算有限小数r的小数位数
+func decimalDigits(r *big.Rat) int {
+	denom := r.Denom()
+	pow := big.NewInt(1)
+	ten := big.NewInt(10)
+	mod := new(big.Int)
+	for digits := 0; digits < maxDecimalDigits; digits++ {
+		if mod.Mod(pow, denom).Sign() == 0 {
+			return digits
+		}
+		pow.Mul(pow, ten)
+	}
+	return maxDecimalDigits
+}
+
+//zeroValue 返回spec声明的简单类型的零值，未知类型返回nil
+func zeroValue(spec *DataSpec) interface{} {
+	switch {
+	case spec.IsNumber():
+		return number(0)
+	case spec.IsString():
+		return ""
+	case spec.IsBoolean():
+		return false
+	case spec.IsInteger():
+		return int64(0)
+	case spec.IsDecimal():
+		return decimalZero(spec.Scale)
+	case spec.IsDateTime():
+		return time.Time{}
+	}
+	return nil
+}
+
+//defaultValue 将spec中声明的默认值转换为源数据中使用的类型，没有声明默认值时返回nil
+func defaultValue(spec *DataSpec) (interface{}, error) {
+	if spec.Default == nil {
+		return nil, nil
+	}
+	if !spec.IsSimple() {
+		return nil, fmt.Errorf("default is only supported on simple fields")
+	}
+	def := yamlValue(spec.Default)
+	values, isSlice := toInterfaces(def)
+	if !spec.IsArray() {
+		if isSlice {
+			return nil, fmt.Errorf("default of a non-array field must not be a list")
+		}
+		return convertSimple(def, spec)
+	}
+	if !isSlice {
+		values = []interface{}{def}
+	}
+	res := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		if value == nil && spec.IsNullable() {
+			res = append(res, nil)
+			continue
+		}
+		v, err := convertSimple(value, spec)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	return makeSimpleSlice(res, spec), nil
+}
+
+//yamlValue 将yaml解析得到的int、float64等数值转换为json.Number，使其与输入数据的处理方式一致
+func yamlValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return json.Number(strconv.Itoa(v))
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10))
+	case uint64:
+		return json.Number(strconv.FormatUint(v, 10))
+	case float64:
+		return json.Number(strconv.FormatFloat(v, 'f', -1, 64))
+	case []interface{}:
+		res := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			res = append(res, yamlValue(e))
+		}
+		return res
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, e := range v {
+			res[fmt.Sprint(key)] = yamlValue(e)
+		}
+		return res
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, e := range v {
+			res[key] = yamlValue(e)
+		}
+		return res
+	}
+	return value
+}
+
+//paramValue 将WithParams传入的Go值按照reflect的Kind转换为与输入数据相同的形式
+//整数与浮点数转换为json.Number，数组与map逐个元素转换，指针取其指向的值
+func paramValue(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	v := reflect.ValueOf(value)
+	if v.Type() == numberType || v.Type() == timeType {
+		return value
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return paramValue(v.Elem().Interface())
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return json.Number(strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return json.Number(strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32:
+		return json.Number(strconv.FormatFloat(v.Float(), 'f', -1, 32))
+	case reflect.Float64:
+		return json.Number(strconv.FormatFloat(v.Float(), 'f', -1, 64))
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		res := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			res = append(res, paramValue(v.Index(i).Interface()))
+		}
+		return res
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		res := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			res[fmt.Sprint(iter.Key().Interface())] = paramValue(iter.Value().Interface())
+		}
+		return res
+	}
+	return value
+}
+
+//makeSimpleSlice 将已经转换完成的values转换为spec声明类型的切片
+//允许为null的数组元素可能为nil，此时返回[]interface{}
+func makeSimpleSlice(values []interface{}, spec *DataSpec) interface{} {
+	if zeroValue(spec) == nil {
+		return nil
+	}
+	// datetime在源数据中为time.Time，在目标数据中为格式化后的值，统一使用[]interface{}
+	if spec.IsNullable() || spec.IsDateTime() {
+		return values
+	}
+	switch {
+	case spec.IsNumber():
+		res := make([]number, 0, len(values))
+		for _, v := range values {
+			res = append(res, v.(number))
+		}
+		return res
+	case spec.IsString():
+		res := make([]string, 0, len(values))
+		for _, v := range values {
+			res = append(res, v.(string))
+		}
+		return res
+	case spec.IsBoolean():
+		res := make([]bool, 0, len(values))
+		for _, v := range values {
+			res = append(res, v.(bool))
+		}
+		return res
+	case spec.IsInteger():
+		res := make([]int64, 0, len(values))
+		for _, v := range values {
+			res = append(res, v.(int64))
+		}
+		return res
+	case spec.IsDecimal():
+		res := make([]json.Number, 0, len(values))
+		for _, v := range values {
+			res = append(res, v.(json.Number))
+		}
+		return res
+	}
+	return nil
+}
+
+//toInterfaces 将任意切片转换为[]interface{}，value不是切片时返回false
+func toInterfaces(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case fanOut:
+		return v, true
+	case []interface{}:
+		return v, true
+	case nil:
+		return nil, false
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice {
+		return nil, false
+	}
+	res := make([]interface{}, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		res = append(res, rv.Index(i).Interface())
+	}
+	return res, true
+}
+
+//rawValue 返回写入any字段的值，fanOut与对象数组转换为普通的数组，去掉源数据中为遍历map与oneOf记录的$key与$variant
+func rawValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			if key != mapKey && key != variantKey {
+				res[key] = rawValue(elem)
+			}
+		}
+		return res
+	case fanOut, []interface{}, []map[string]interface{}:
+		elems, _ := toInterfaces(v)
+		res := make([]interface{}, 0, len(elems))
+		for _, elem := range elems {
+			res = append(res, rawValue(elem))
+		}
+		return res
+	}
+	return value
+}
+
+func typeName(value interface{}) string {
+	if value == nil {
+		return "nil"
+	}
+	return reflect.TypeOf(value).String()
+}
@@ -0,0 +1,168 @@
+package datamapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//defaultLayout datetime未声明layout时使用的格式
+const defaultLayout = time.RFC3339Nano
+
+var (
+	//namedLayouts layout中可以直接使用的格式名称
+	namedLayouts = map[string]string{
+		"ANSIC":       time.ANSIC,
+		"UnixDate":    time.UnixDate,
+		"RFC822":      time.RFC822,
+		"RFC822Z":     time.RFC822Z,
+		"RFC1123":     time.RFC1123,
+		"RFC1123Z":    time.RFC1123Z,
+		"RFC3339":     time.RFC3339,
+		"RFC3339Nano": time.RFC3339Nano,
+		"Kitchen":     time.Kitchen,
+		"DateTime":    "2006-01-02 15:04:05",
+		"DateOnly":    "2006-01-02",
+		"TimeOnly":    "15:04:05",
+	}
+
+	//epochUnits 使用时间戳表示的layout，值为时间戳单位对应的纳秒数
+	epochUnits = map[string]int64{
+		"epoch_s":  int64(time.Second),
+		"epoch_ms": int64(time.Millisecond),
+		"epoch_us": int64(time.Microsecond),
+		"epoch_ns": int64(time.Nanosecond),
+	}
+
+	//locations 已经加载的时区，避免每次转换都读取时区数据
+	locations sync.Map
+)
+
+//layout 返回spec声明的时间格式，并判断其是否为时间戳格式
+func layout(spec *DataSpec) (string, int64) {
+	if unit, ok := epochUnits[spec.Layout]; ok {
+		return spec.Layout, unit
+	}
+	if spec.Layout == "" {
+		return defaultLayout, 0
+	}
+	if l, ok := namedLayouts[spec.Layout]; ok {
+		return l, 0
+	}
+	return spec.Layout, 0
+}
+
+//loadLocation 加载spec声明的时区，支持IANA时区名称、Local、UTC以及+08:00形式的固定偏移
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" || name == "UTC" {
+		return time.UTC, nil
+	}
+	if name == "Local" {
+		return time.Local, nil
+	}
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+
+	var loc *time.Location
+	if name[0] == '+' || name[0] == '-' {
+		t, err := time.Parse("-07:00", name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q", name)
+		}
+		_, offset := t.Zone()
+		loc = time.FixedZone(name, offset)
+	} else {
+		var err error
+		if loc, err = time.LoadLocation(name); err != nil {
+			return nil, err
+		}
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+//toDateTime 按照spec声明的layout和timezone将value解析为time.Time
+func toDateTime(value interface{}, spec *DataSpec) (interface{}, error) {
+	if t, ok := value.(time.Time); ok {
+		return t, nil
+	}
+	loc, err := loadLocation(spec.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	l, unit := layout(spec)
+	if unit > 0 {
+		var text string
+		switch v := value.(type) {
+		case json.Number:
+			text = v.String()
+		case string:
+			text = strings.TrimSpace(v)
+		case int64:
+			text = strconv.FormatInt(v, 10)
+		case number:
+			text = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return nil, fmt.Errorf("can't parse value type: %s", typeName(value))
+		}
+		r, ok := new(big.Rat).SetString(text)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a %s timestamp", text, spec.Layout)
+		}
+		// 通过big.Rat计算纳秒数，避免毫秒级时间戳经过float64丢失精度
+		ns := new(big.Rat).Mul(r, new(big.Rat).SetInt64(unit))
+		n := new(big.Int).Quo(ns.Num(), ns.Denom())
+		if !n.IsInt64() {
+			return nil, fmt.Errorf("%s timestamp %s is out of range", spec.Layout, text)
+		}
+		return time.Unix(0, n.Int64()).In(loc), nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("can't parse value type: %s", typeName(value))
+	}
+	t, err := time.ParseInLocation(l, strings.TrimSpace(s), loc)
+	if err != nil {
+		// 解析失败时不写入零值时间，宽松模式下目标保持为null
+		return nil, err
+	}
+	return t, nil
+}
+
+//formatDateTime 按照spec声明的layout和timezone输出时间
+//时间戳格式输出为int64，其他格式输出为字符串
+func formatDateTime(t time.Time, spec *DataSpec) interface{} {
+	l, unit := layout(spec)
+	if unit > 0 {
+		return t.UnixNano() / unit
+	}
+	if loc, err := loadLocation(spec.Timezone); err == nil {
+		t = t.In(loc)
+	}
+	return t.Format(l)
+}
+
+//outputValue 将写入目标的值转换为最终输出的形式，目前只有datetime需要按照layout格式化
+func outputValue(value interface{}, spec *DataSpec) interface{} {
+	if !spec.IsDateTime() {
+		return value
+	}
+	switch v := value.(type) {
+	case time.Time:
+		return formatDateTime(v, spec)
+	case []interface{}:
+		res := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			res = append(res, outputValue(e, spec))
+		}
+		return res
+	}
+	return value
+}
@@ -0,0 +1,111 @@
+package datamapper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//ErrRequired 声明为required的字段在源数据中缺失，或者目标字段没有被映射
+var ErrRequired = errors.New("required field is missing")
+
+//ErrMaxDepth 数据的嵌套层级超过了WithMaxDepth设置的最大深度，超出的部分会被丢弃
+var ErrMaxDepth = errors.New("max depth exceeded")
+
+//ErrNoVariant 源数据中的对象没有匹配oneOf中的任何一个候选类型
+var ErrNoVariant = errors.New("no matching oneOf variant")
+
+//ErrCardinality 数组写入了单个值的字段，需要通过[0]等下标明确选择元素，宽松模式下取第一个元素并记录日志
+var ErrCardinality = errors.New("array value for a single field, use an index such as [0] to pick an element")
+
+//MappingError 转换过程中单个值无法按照规格转换时产生的错误
+type MappingError struct {
+	//SourcePath 源数据中的路径，数组元素使用[下标]表示
+	SourcePath string
+	//TargetPath 目标数据中的路径，解析源数据阶段产生的错误该值为空
+	TargetPath string
+	//Expected 规格中声明的类型，transform执行失败时为空
+	Expected string
+	//Value 实际的值，字段缺失或者表达式求值失败时为nil
+	Value interface{}
+	//Err 转换失败的原因，可能为nil
+	Err error
+}
+
+//Error 实现error接口
+func (e *MappingError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("mapping")
+	if e.SourcePath != "" {
+		sb.WriteString(" ")
+		sb.WriteString(e.SourcePath)
+	}
+	if e.TargetPath != "" {
+		sb.WriteString(" -> ")
+		sb.WriteString(e.TargetPath)
+	}
+	if e.Expected != "" {
+		fmt.Fprintf(&sb, ": expected %s, got %s(%v)", e.Expected, typeName(e.Value), e.Value)
+	} else if e.Value != nil || e.Err == nil {
+		fmt.Fprintf(&sb, ": got %s(%v)", typeName(e.Value), e.Value)
+	}
+	if e.Err != nil {
+		sb.WriteString(": ")
+		sb.WriteString(e.Err.Error())
+	}
+	return sb.String()
+}
+
+//Unwrap 返回转换失败的原因
+func (e *MappingError) Unwrap() error {
+	return e.Err
+}
+
+//MultiError 汇总一次转换中产生的所有错误
+type MultiError []error
+
+//Error 实现error接口
+func (m MultiError) Error() string {
+	msgs := make([]string, 0, len(m))
+	for _, err := range m {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d errors occurred: %s", len(m), strings.Join(msgs, "; "))
+}
+
+//Unwrap 返回所有的错误，Go 1.20及以上版本的errors.Is与errors.As会使用该方法
+func (m MultiError) Unwrap() []error {
+	return m
+}
+
+//Is 判断其中是否有错误与target匹配，使得Go 1.20以下版本的errors.Is也可以检查所有的错误
+func (m MultiError) Is(target error) bool {
+	for _, err := range m {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+//As 将第一个与target类型匹配的错误赋值给target
+func (m MultiError) As(target interface{}) bool {
+	for _, err := range m {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+//errorMode 转换过程中遇到错误时的处理方式
+type errorMode int
+
+const (
+	//lenient 记录日志后继续转换，与早期版本的行为一致
+	lenient errorMode = iota
+	//strict 遇到错误时转换失败并返回第一个错误
+	strict
+	//collect 转换失败并返回包含所有错误的MultiError
+	collect
+)
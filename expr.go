@@ -0,0 +1,638 @@
+package datamapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+//env 表达式求值时的环境
+type env struct {
+	//root 解析后的源数据
+	root map[string]interface{}
+	//scope 当前所在的对象，路径优先在scope中查找，找不到时再从root中查找
+	scope map[string]interface{}
+	//ctx 传递给函数的上下文
+	ctx *FuncContext
+}
+
+//expr 编译后的表达式节点
+//表达式中的路径经过对象数组时得到fanOut，运算符与函数会对fanOut中的元素逐个计算
+type expr interface {
+	eval(e *env) (interface{}, error)
+}
+
+//literal 字面量
+type literal struct {
+	value interface{}
+}
+
+//pathRef 对源数据路径的引用，例如 data.voltage 或 properties[name=="CPU使用率"].val
+type pathRef struct {
+	text string
+	segs []segment
+}
+
+//unary 一元运算，支持 - 与 !
+type unary struct {
+	op string
+	x  expr
+}
+
+//binary 二元运算
+type binary struct {
+	op   string
+	x, y expr
+}
+
+//conditional 条件运算 c ? a : b
+type conditional struct {
+	c, a, b expr
+}
+
+//call 函数调用
+type call struct {
+	fn   *function
+	args []expr
+}
+
+//compileExpr 编译表达式，语法错误、未定义的函数以及参数个数错误都会在编译时返回
+func compileExpr(src string) (expr, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: []rune(src), tokens: tokens}
+	x, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	return x, nil
+}
+
+//parser 递归下降的表达式解析器
+//优先级从低到高依次为 ?: || && ==/!= </<=/>/>= +/- */% 一元运算
+type parser struct {
+	src    []rune
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+//accept 当下一个词法单元为符号s时消耗它并返回true
+func (p *parser) accept(s string) bool {
+	if t := p.peek(); t.kind == tokenPunct && t.text == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) error {
+	if !p.accept(s) {
+		t := p.peek()
+		if t.kind == tokenEOF {
+			return fmt.Errorf("%q expected at end", s)
+		}
+		return fmt.Errorf("%q expected, got %q at %d", s, t.text, t.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseExpr() (expr, error) {
+	c, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept("?") {
+		return c, nil
+	}
+	a, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	b, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return &conditional{c: c, a: a, b: b}, nil
+}
+
+//binaryLevels 二元运算符的优先级，下标越大优先级越高
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) parseBinary(level int) (expr, error) {
+	if level == len(binaryLevels) {
+		return p.parseUnary()
+	}
+	x, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		for _, candidate := range binaryLevels[level] {
+			if p.accept(candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return x, nil
+		}
+		y, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &binary{op: op, x: x, y: y}
+	}
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	for _, op := range []string{"-", "!"} {
+		if p.accept(op) {
+			x, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			// 负数字面量直接折叠为常量
+			if l, ok := x.(*literal); ok && op == "-" {
+				if n, ok := l.value.(json.Number); ok {
+					return &literal{value: json.Number("-" + n.String())}, nil
+				}
+			}
+			return &unary{op: op, x: x}, nil
+		}
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber, tokenString:
+		return &literal{value: t.value}, nil
+	case tokenPunct:
+		if t.text == "(" {
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return &literal{value: true}, nil
+		case "false":
+			return &literal{value: false}, nil
+		case "null":
+			return &literal{value: nil}, nil
+		}
+		if p.accept("(") {
+			return p.parseCall(t)
+		}
+		return p.parsePath(t)
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+func (p *parser) parseCall(name token) (expr, error) {
+	fn := lookupFunc(name.text)
+	if fn == nil {
+		return nil, fmt.Errorf("function %s is not defined", name.text)
+	}
+	args, err := p.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+	if err := fn.checkArgs(len(args)); err != nil {
+		return nil, err
+	}
+	return &call{fn: fn, args: args}, nil
+}
+
+//parseArgs 解析左括号之后以逗号分隔的参数列表，直到右括号为止
+func (p *parser) parseArgs() ([]expr, error) {
+	var args []expr
+	if p.accept(")") {
+		return args, nil
+	}
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.accept(")") {
+			return args, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+//parsePath 解析以.分隔的路径，字段名之后可以使用方括号声明下标、切片或者数组元素的筛选条件
+func (p *parser) parsePath(first token) (expr, error) {
+	ref := &pathRef{segs: []segment{{key: first.text}}}
+	end := first.pos + len([]rune(first.text))
+	for {
+		if t := p.peek(); t.kind == tokenPunct && t.text == "[" {
+			closing, err := p.closingBracket()
+			if err != nil {
+				return nil, err
+			}
+			seg := &ref.segs[len(ref.segs)-1]
+			if err := seg.bracket(string(p.src[t.pos+1 : closing.pos])); err != nil {
+				return nil, err
+			}
+			end = closing.pos + 1
+			continue
+		}
+		if !p.accept(".") {
+			break
+		}
+		t := p.next()
+		// map字段之后的*表示遍历map中的值
+		if t.kind != tokenIdent && !(t.kind == tokenPunct && t.text == wildcardKey) {
+			return nil, fmt.Errorf("field name expected after '.' at %d", t.pos)
+		}
+		ref.segs = append(ref.segs, segment{key: t.text})
+		end = t.pos + len([]rune(t.text))
+	}
+	ref.text = string(p.src[first.pos:end])
+	return ref, nil
+}
+
+//closingBracket 消耗从当前的左方括号到对应的右方括号之间的所有词法单元，并返回右方括号
+func (p *parser) closingBracket() (token, error) {
+	open := p.peek()
+	depth := 0
+	for {
+		t := p.next()
+		switch {
+		case t.kind == tokenEOF:
+			return t, fmt.Errorf("unclosed '[' at %d", open.pos)
+		case t.kind != tokenPunct:
+		case t.text == "[":
+			depth++
+		case t.text == "]":
+			if depth--; depth == 0 {
+				return t, nil
+			}
+		}
+	}
+}
+
+//walkExpr 深度优先遍历表达式中的所有节点
+func walkExpr(x expr, fn func(expr)) {
+	if x == nil {
+		return
+	}
+	fn(x)
+	switch n := x.(type) {
+	case *unary:
+		walkExpr(n.x, fn)
+	case *binary:
+		walkExpr(n.x, fn)
+		walkExpr(n.y, fn)
+	case *conditional:
+		walkExpr(n.c, fn)
+		walkExpr(n.a, fn)
+		walkExpr(n.b, fn)
+	case *call:
+		for _, arg := range n.args {
+			walkExpr(arg, fn)
+		}
+	}
+}
+
+//pathRefs 返回表达式中引用的所有路径，用于静态检查
+func pathRefs(x expr) []*pathRef {
+	var res []*pathRef
+	walkExpr(x, func(n expr) {
+		if ref, ok := n.(*pathRef); ok {
+			res = append(res, ref)
+		}
+	})
+	return res
+}
+
+func (l *literal) eval(*env) (interface{}, error) {
+	return l.value, nil
+}
+
+func (r *pathRef) eval(e *env) (interface{}, error) {
+	if e.scope != nil {
+		if v, ok, err := getSourceData(e, e.scope, r.segs); ok || err != nil {
+			return v, err
+		}
+	}
+	v, _, err := getSourceData(e, e.root, r.segs)
+	return v, err
+}
+
+func (u *unary) eval(e *env) (interface{}, error) {
+	x, err := u.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	return vectorize([]interface{}{x}, func(args []interface{}) (interface{}, error) {
+		if u.op == "!" {
+			return !truthy(args[0]), nil
+		}
+		if args[0] == nil {
+			return nil, nil
+		}
+		r, err := toRat(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return ratNumber(r.Neg(r)), nil
+	})
+}
+
+func (b *binary) eval(e *env) (interface{}, error) {
+	x, err := b.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	// 对于单个值的逻辑运算进行短路求值
+	if _, ok := x.(fanOut); !ok {
+		if b.op == "&&" && !truthy(x) {
+			return false, nil
+		}
+		if b.op == "||" && truthy(x) {
+			return true, nil
+		}
+	}
+	y, err := b.y.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	return vectorize([]interface{}{x, y}, func(args []interface{}) (interface{}, error) {
+		return binaryOp(b.op, args[0], args[1])
+	})
+}
+
+func (c *conditional) eval(e *env) (interface{}, error) {
+	cond, err := c.c.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := cond.(fanOut); !ok {
+		if truthy(cond) {
+			return c.a.eval(e)
+		}
+		return c.b.eval(e)
+	}
+	a, err := c.a.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	b, err := c.b.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	return vectorize([]interface{}{cond, a, b}, func(args []interface{}) (interface{}, error) {
+		if truthy(args[0]) {
+			return args[1], nil
+		}
+		return args[2], nil
+	})
+}
+
+func (c *call) eval(e *env) (interface{}, error) {
+	args := make([]interface{}, 0, len(c.args))
+	for _, arg := range c.args {
+		v, err := arg.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	return c.fn.invoke(e.ctx, args)
+}
+
+//invoke 调用函数，非聚合函数会对fanOut中的元素逐个调用
+func (f *function) invoke(ctx *FuncContext, args []interface{}) (interface{}, error) {
+	if f.aggregate {
+		for i, arg := range args {
+			if list, ok := arg.(fanOut); ok {
+				args[i] = []interface{}(list)
+			}
+		}
+		return f.call(ctx, args)
+	}
+	return vectorize(args, func(args []interface{}) (interface{}, error) {
+		return f.call(ctx, args)
+	})
+}
+
+//vectorize 当参数中存在fanOut时，按照下标对每一组元素调用fn并返回fanOut，单个值会被广播到每一组中
+func vectorize(args []interface{}, fn func(args []interface{}) (interface{}, error)) (interface{}, error) {
+	n := -1
+	for _, arg := range args {
+		if list, ok := arg.(fanOut); ok {
+			if n >= 0 && len(list) != n {
+				return nil, fmt.Errorf("array length mismatch: %d and %d", n, len(list))
+			}
+			n = len(list)
+		}
+	}
+	if n < 0 {
+		return fn(args)
+	}
+
+	res := make(fanOut, 0, n)
+	for i := 0; i < n; i++ {
+		elem := make([]interface{}, len(args))
+		for j, arg := range args {
+			if list, ok := arg.(fanOut); ok {
+				elem[j] = list[i]
+			} else {
+				elem[j] = arg
+			}
+		}
+		v, err := vectorize(elem, fn)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+//truthy 判断值在条件中是否为真：null、false、0、空字符串以及"false"为假
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		// 只有空字符串与"false"为假，"0"、"False"等其他字符串都为真
+		return v != "" && v != "false"
+	case time.Time:
+		return !v.IsZero()
+	}
+	if r, err := toRat(value); err == nil {
+		return r.Sign() != 0
+	}
+	return true
+}
+
+//binaryOp 计算二元运算
+func binaryOp(op string, x, y interface{}) (interface{}, error) {
+	switch op {
+	case "&&":
+		return truthy(x) && truthy(y), nil
+	case "||":
+		return truthy(x) || truthy(y), nil
+	case "==":
+		return equal(x, y), nil
+	case "!=":
+		return !equal(x, y), nil
+	case "<", "<=", ">", ">=":
+		if x == nil || y == nil {
+			return false, nil
+		}
+		c, err := compare(x, y)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	}
+
+	// 算术运算中任意一边为null时结果为null
+	if x == nil || y == nil {
+		return nil, nil
+	}
+	if op == "+" {
+		_, xs := x.(string)
+		_, ys := y.(string)
+		if xs || ys {
+			return str(x) + str(y), nil
+		}
+	}
+	a, err := toRat(x)
+	if err != nil {
+		return nil, err
+	}
+	b, err := toRat(y)
+	if err != nil {
+		return nil, err
+	}
+	var r *big.Rat
+	switch op {
+	case "+":
+		r = new(big.Rat).Add(a, b)
+	case "-":
+		r = new(big.Rat).Sub(a, b)
+	case "*":
+		r = new(big.Rat).Mul(a, b)
+	case "/":
+		if b.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		r = new(big.Rat).Quo(a, b)
+	case "%":
+		if r, err = ratMod(a, b); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported operator %s", op)
+	}
+	return ratNumber(r), nil
+}
+
+//equal 判断两个值是否相等，数值之间按照数值大小比较
+func equal(x, y interface{}) bool {
+	if x == nil || y == nil {
+		return x == nil && y == nil
+	}
+	if c, err := compare(x, y); err == nil {
+		return c == 0
+	}
+	return fmt.Sprint(x) == fmt.Sprint(y)
+}
+
+//compare 比较两个值的大小，两个字符串按照字典序比较，其他情况尝试按照数值或时间比较
+func compare(x, y interface{}) (int, error) {
+	xs, xok := x.(string)
+	ys, yok := y.(string)
+	if xok && yok {
+		return strings.Compare(xs, ys), nil
+	}
+	if xt, ok := x.(time.Time); ok {
+		if yt, ok := y.(time.Time); ok {
+			switch {
+			case xt.Before(yt):
+				return -1, nil
+			case xt.After(yt):
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	if xb, ok := x.(bool); ok {
+		if yb, ok := y.(bool); ok {
+			if xb == yb {
+				return 0, nil
+			}
+			if !xb {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	a, err := toRat(x)
+	if err != nil {
+		return 0, fmt.Errorf("can't compare %s with %s", typeName(x), typeName(y))
+	}
+	b, err := toRat(y)
+	if err != nil {
+		return 0, fmt.Errorf("can't compare %s with %s", typeName(x), typeName(y))
+	}
+	return a.Cmp(b), nil
+}
@@ -0,0 +1,448 @@
+package datamapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//maxPadWidth padLeft与padRight允许的最大宽度，避免表达式生成过大的字符串
+const maxPadWidth = 4096
+
+//function 可以在transform与表达式中调用的函数
+//在transform中调用时args[0]为管道中传入的值，其余为transform中声明的参数
+type function struct {
+	name string
+	//minArgs与maxArgs限制参数的个数（包含管道传入的值），maxArgs为-1时表示不限制
+	minArgs int
+	maxArgs int
+	//aggregate 为true时参数中的数组会整体传入函数，否则对数组中的元素逐个调用
+	aggregate bool
+	//volatile 为true时每次调用的结果都不同，例如uuid与seq
+	volatile bool
+	call     func(ctx *FuncContext, args []interface{}) (interface{}, error)
+}
+
+//checkArgs 检查参数个数是否满足函数的要求
+func (f *function) checkArgs(n int) error {
+	if n < f.minArgs || (f.maxArgs >= 0 && n > f.maxArgs) {
+		if f.maxArgs < 0 {
+			return fmt.Errorf("function %s expects at least %d arguments, got %d", f.name, f.minArgs, n)
+		}
+		if f.minArgs == f.maxArgs {
+			return fmt.Errorf("function %s expects %d arguments, got %d", f.name, f.minArgs, n)
+		}
+		return fmt.Errorf("function %s expects %d to %d arguments, got %d", f.name, f.minArgs, f.maxArgs, n)
+	}
+	return nil
+}
+
+//builtins 内置的函数库
+var builtins = map[string]*function{}
+
+//lookupFunc 根据名称查找函数，内置函数优先，不存在时返回nil
+func lookupFunc(name string) *function {
+	if fn, ok := builtins[name]; ok {
+		return fn
+	}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return registry[name]
+}
+
+func init() {
+	// 字符串
+	registerBuiltin("trim", 1, 2, func(args []interface{}) (interface{}, error) {
+		if len(args) == 2 {
+			return strings.Trim(str(args[0]), str(args[1])), nil
+		}
+		return strings.TrimSpace(str(args[0])), nil
+	})
+	registerBuiltin("trimLeft", 2, 2, func(args []interface{}) (interface{}, error) {
+		return strings.TrimLeft(str(args[0]), str(args[1])), nil
+	})
+	registerBuiltin("trimRight", 2, 2, func(args []interface{}) (interface{}, error) {
+		return strings.TrimRight(str(args[0]), str(args[1])), nil
+	})
+	registerBuiltin("trimPrefix", 2, 2, func(args []interface{}) (interface{}, error) {
+		return strings.TrimPrefix(str(args[0]), str(args[1])), nil
+	})
+	registerBuiltin("trimSuffix", 2, 2, func(args []interface{}) (interface{}, error) {
+		return strings.TrimSuffix(str(args[0]), str(args[1])), nil
+	})
+	registerBuiltin("upper", 1, 1, func(args []interface{}) (interface{}, error) {
+		return strings.ToUpper(str(args[0])), nil
+	})
+	registerBuiltin("lower", 1, 1, func(args []interface{}) (interface{}, error) {
+		return strings.ToLower(str(args[0])), nil
+	})
+	registerBuiltin("camelCase", 1, 1, func(args []interface{}) (interface{}, error) {
+		return camelCase(str(args[0])), nil
+	})
+	registerBuiltin("snakeCase", 1, 1, func(args []interface{}) (interface{}, error) {
+		return snakeCase(str(args[0])), nil
+	})
+	registerBuiltin("replace", 3, 3, func(args []interface{}) (interface{}, error) {
+		return strings.ReplaceAll(str(args[0]), str(args[1]), str(args[2])), nil
+	})
+	registerBuiltin("substr", 2, 3, func(args []interface{}) (interface{}, error) {
+		runes := []rune(str(args[0]))
+		start, err := toInt(args[1])
+		if err != nil {
+			return nil, err
+		}
+		end := len(runes)
+		if len(args) == 3 {
+			length, err := toInt(args[2])
+			if err != nil {
+				return nil, err
+			}
+			end = start + length
+		}
+		start, end = clamp(start, len(runes)), clamp(end, len(runes))
+		if start >= end {
+			return "", nil
+		}
+		return string(runes[start:end]), nil
+	})
+	registerBuiltin("concat", 1, -1, func(args []interface{}) (interface{}, error) {
+		var sb strings.Builder
+		for _, arg := range args {
+			sb.WriteString(str(arg))
+		}
+		return sb.String(), nil
+	})
+	registerBuiltin("length", 1, 1, func(args []interface{}) (interface{}, error) {
+		return int64(utf8.RuneCountInString(str(args[0]))), nil
+	})
+
+	// 填充
+	registerBuiltin("padLeft", 2, 3, func(args []interface{}) (interface{}, error) {
+		return pad(args, true)
+	})
+	registerBuiltin("padRight", 2, 3, func(args []interface{}) (interface{}, error) {
+		return pad(args, false)
+	})
+
+	// 格式化
+	registerBuiltin("format", 2, 2, func(args []interface{}) (interface{}, error) {
+		return fmt.Sprintf(str(args[1]), formatArg(args[0])), nil
+	})
+
+	// 数学运算，计算过程使用big.Rat，不会经过float64丢失精度
+	registerArith("add", func(x, y *big.Rat) (*big.Rat, error) { return new(big.Rat).Add(x, y), nil })
+	registerArith("sub", func(x, y *big.Rat) (*big.Rat, error) { return new(big.Rat).Sub(x, y), nil })
+	registerArith("mul", func(x, y *big.Rat) (*big.Rat, error) { return new(big.Rat).Mul(x, y), nil })
+	registerArith("div", func(x, y *big.Rat) (*big.Rat, error) {
+		if y.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return new(big.Rat).Quo(x, y), nil
+	})
+	registerArith("mod", ratMod)
+	registerArith("min", func(x, y *big.Rat) (*big.Rat, error) {
+		if x.Cmp(y) <= 0 {
+			return x, nil
+		}
+		return y, nil
+	})
+	registerArith("max", func(x, y *big.Rat) (*big.Rat, error) {
+		if x.Cmp(y) >= 0 {
+			return x, nil
+		}
+		return y, nil
+	})
+	registerBuiltin("abs", 1, 1, func(args []interface{}) (interface{}, error) {
+		x, err := toRat(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return ratNumber(new(big.Rat).Abs(x)), nil
+	})
+
+	// 条件
+	registerBuiltin("if", 3, 3, func(args []interface{}) (interface{}, error) {
+		if truthy(args[0]) {
+			return args[1], nil
+		}
+		return args[2], nil
+	})
+	registerBuiltin("coalesce", 1, -1, func(args []interface{}) (interface{}, error) {
+		for _, arg := range args {
+			if arg != nil && arg != "" {
+				return arg, nil
+			}
+		}
+		return nil, nil
+	})
+
+	// 聚合，参数为数组
+	registerAggregate("count", 1, 1, func(args []interface{}) (interface{}, error) {
+		values, _ := toInterfaces(args[0])
+		return int64(len(values)), nil
+	})
+	registerAggregate("sum", 1, 1, func(args []interface{}) (interface{}, error) {
+		values, _ := toInterfaces(args[0])
+		sum := new(big.Rat)
+		for _, v := range values {
+			if v == nil {
+				continue
+			}
+			r, err := toRat(v)
+			if err != nil {
+				return nil, err
+			}
+			sum.Add(sum, r)
+		}
+		return ratNumber(sum), nil
+	})
+	registerAggregate("join", 2, 2, func(args []interface{}) (interface{}, error) {
+		values, _ := toInterfaces(args[0])
+		strs := make([]string, 0, len(values))
+		for _, v := range values {
+			strs = append(strs, str(v))
+		}
+		return strings.Join(strs, str(args[1])), nil
+	})
+	registerAggregate("first", 1, 1, func(args []interface{}) (interface{}, error) {
+		if values, _ := toInterfaces(args[0]); len(values) > 0 {
+			return values[0], nil
+		}
+		return nil, nil
+	})
+	registerAggregate("last", 1, 1, func(args []interface{}) (interface{}, error) {
+		if values, _ := toInterfaces(args[0]); len(values) > 0 {
+			return values[len(values)-1], nil
+		}
+		return nil, nil
+	})
+
+	// 转换表，表名在lookups中声明
+	builtins["lookup"] = &function{name: "lookup", minArgs: 2, maxArgs: 3, call: lookupValue}
+
+	// 取整
+	registerBuiltin("round", 1, 2, func(args []interface{}) (interface{}, error) {
+		x, err := toRat(args[0])
+		if err != nil {
+			return nil, err
+		}
+		digits := 0
+		if len(args) == 2 {
+			if digits, err = toInt(args[1]); err != nil {
+				return nil, err
+			}
+		}
+		if digits < 0 || digits > maxDecimalDigits {
+			return nil, fmt.Errorf("round digits must be between 0 and %d, got %d", maxDecimalDigits, digits)
+		}
+		// FloatString按照四舍五入（远离0）进行舍入
+		return json.Number(x.FloatString(digits)), nil
+	})
+	registerBuiltin("floor", 1, 1, func(args []interface{}) (interface{}, error) {
+		x, err := toRat(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(ratFloor(x).String()), nil
+	})
+	registerBuiltin("ceil", 1, 1, func(args []interface{}) (interface{}, error) {
+		x, err := toRat(args[0])
+		if err != nil {
+			return nil, err
+		}
+		// ceil(x) = -floor(-x)
+		return json.Number(new(big.Int).Neg(ratFloor(new(big.Rat).Neg(x))).String()), nil
+	})
+}
+
+func registerBuiltin(name string, minArgs, maxArgs int, call func(args []interface{}) (interface{}, error)) {
+	builtins[name] = &function{name: name, minArgs: minArgs, maxArgs: maxArgs, call: ignoreContext(call)}
+}
+
+//registerAggregate 注册聚合函数
+func registerAggregate(name string, minArgs, maxArgs int, call func(args []interface{}) (interface{}, error)) {
+	builtins[name] = &function{name: name, minArgs: minArgs, maxArgs: maxArgs, aggregate: true, call: ignoreContext(call)}
+}
+
+//ignoreContext 内置函数不需要调用时的上下文
+func ignoreContext(call func(args []interface{}) (interface{}, error)) func(*FuncContext, []interface{}) (interface{}, error) {
+	return func(_ *FuncContext, args []interface{}) (interface{}, error) {
+		return call(args)
+	}
+}
+
+//registerArith 注册二元的数学运算函数
+func registerArith(name string, op func(x, y *big.Rat) (*big.Rat, error)) {
+	registerBuiltin(name, 2, 2, func(args []interface{}) (interface{}, error) {
+		x, err := toRat(args[0])
+		if err != nil {
+			return nil, err
+		}
+		y, err := toRat(args[1])
+		if err != nil {
+			return nil, err
+		}
+		r, err := op(x, y)
+		if err != nil {
+			return nil, err
+		}
+		return ratNumber(r), nil
+	})
+}
+
+//str 将值转换为字符串，用于字符串函数的参数
+func str(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	if s, err := toString(value); err == nil {
+		return s.(string)
+	}
+	return fmt.Sprint(value)
+}
+
+//formatArg 将值转换为fmt能够按照格式输出的类型
+func formatArg(value interface{}) interface{} {
+	if n, ok := value.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+		if f, err := n.Float64(); err == nil {
+			return f
+		}
+	}
+	return value
+}
+
+//toRat 将值转换为big.Rat，用于数学运算
+func toRat(value interface{}) (*big.Rat, error) {
+	d, err := toDecimal(value, nil)
+	if err != nil {
+		if b, ok := value.(bool); ok {
+			if b {
+				return big.NewRat(1, 1), nil
+			}
+			return new(big.Rat), nil
+		}
+		return nil, err
+	}
+	r, _ := new(big.Rat).SetString(d.(json.Number).String())
+	return r, nil
+}
+
+//toInt 将函数参数转换为int
+func toInt(value interface{}) (int, error) {
+	i, err := toInteger(value)
+	if err != nil {
+		return 0, err
+	}
+	return int(i.(int64)), nil
+}
+
+//ratNumber 将运算结果转换为json.Number，无限小数保留16位小数
+func ratNumber(r *big.Rat) json.Number {
+	if r.IsInt() {
+		return json.Number(r.Num().String())
+	}
+	digits := decimalDigits(r)
+	if digits >= maxDecimalDigits {
+		digits = 16
+	}
+	return json.Number(r.FloatString(digits))
+}
+
+func ratFloor(x *big.Rat) *big.Int {
+	// big.Int的Div为欧几里得除法，除数为正数时结果即为向下取整
+	return new(big.Int).Div(x.Num(), x.Denom())
+}
+
+func ratMod(x, y *big.Rat) (*big.Rat, error) {
+	if y.Sign() == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	q := new(big.Rat).Quo(x, y)
+	// 与Go的%保持一致，结果的符号与被除数相同
+	t := new(big.Int).Quo(q.Num(), q.Denom())
+	return new(big.Rat).Sub(x, new(big.Rat).Mul(y, new(big.Rat).SetInt(t))), nil
+}
+
+//camelCase 将以_、-或空格分隔的单词转换为小驼峰，例如 device_id 转换为 deviceId
+func camelCase(s string) string {
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return r == '_' || r == '-' || unicode.IsSpace(r)
+	})
+	var sb strings.Builder
+	for i, word := range words {
+		runes := []rune(word)
+		if i == 0 {
+			runes[0] = unicode.ToLower(runes[0])
+		} else {
+			runes[0] = unicode.ToUpper(runes[0])
+		}
+		sb.WriteString(string(runes))
+	}
+	return sb.String()
+}
+
+//snakeCase 将驼峰或者以-、空格分隔的单词转换为小写并以_分隔，例如 deviceID 转换为 device_id
+func snakeCase(s string) string {
+	runes := []rune(s)
+	var sb strings.Builder
+	for i, r := range runes {
+		switch {
+		case r == '-' || unicode.IsSpace(r):
+			sb.WriteRune('_')
+		case unicode.IsUpper(r):
+			// 小写字母或数字之后的大写字母，以及连续大写字母中最后一个之后接小写字母的位置为单词的开始
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				(unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				sb.WriteRune('_')
+			}
+			sb.WriteRune(unicode.ToLower(r))
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+//pad 使用padding将值填充到指定的宽度，默认使用空格填充
+func pad(args []interface{}, left bool) (interface{}, error) {
+	s := str(args[0])
+	width, err := toInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if width > maxPadWidth {
+		return nil, fmt.Errorf("pad width must not exceed %d, got %d", maxPadWidth, width)
+	}
+	padding := " "
+	if len(args) == 3 {
+		padding = str(args[2])
+	}
+	if padding == "" {
+		return nil, fmt.Errorf("padding must not be empty")
+	}
+	n := width - utf8.RuneCountInString(s)
+	if n <= 0 {
+		return s, nil
+	}
+	fill := []rune(strings.Repeat(padding, n))[:n]
+	if left {
+		return string(fill) + s, nil
+	}
+	return s + string(fill), nil
+}
+
+func clamp(i, n int) int {
+	if i < 0 {
+		return 0
+	}
+	if i > n {
+		return n
+	}
+	return i
+}
@@ -0,0 +1,130 @@
+package datamapper
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+//generator 生成函数uuid、uuidv7、seq与now使用的时钟、随机数来源与序列
+type generator struct {
+	now    func() time.Time
+	random io.Reader
+	//seq 规格的序列，由同一个DataDefine编译得到的Plan共享
+	seq *sequence
+}
+
+//sequence 单调递增的序列，从1开始
+type sequence struct {
+	mu sync.Mutex
+	n  int64
+}
+
+//next 返回序列的下一个值
+func (s *sequence) next() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n++
+	return s.n
+}
+
+//defaultGenerator 没有转换上下文时使用的生成器，例如直接调用Validate
+var defaultGenerator = &generator{now: time.Now, random: rand.Reader, seq: &sequence{}}
+
+//generator 返回函数调用时使用的生成器
+func (ctx *FuncContext) generator() *generator {
+	if ctx == nil || ctx.gen == nil || ctx.gen.seq == nil {
+		return defaultGenerator
+	}
+	return ctx.gen
+}
+
+func init() {
+	// 生成值，写入数组时对每一个元素分别调用，now在同一个位置的结果相同因此不需要
+	builtins["uuid"] = &function{name: "uuid", minArgs: 0, maxArgs: 0, volatile: true, call: func(ctx *FuncContext, _ []interface{}) (interface{}, error) {
+		return newUUIDv4(ctx.generator())
+	}}
+	builtins["uuidv7"] = &function{name: "uuidv7", minArgs: 0, maxArgs: 0, volatile: true, call: func(ctx *FuncContext, _ []interface{}) (interface{}, error) {
+		return newUUIDv7(ctx.generator())
+	}}
+	builtins["seq"] = &function{name: "seq", minArgs: 0, maxArgs: 0, volatile: true, call: func(ctx *FuncContext, _ []interface{}) (interface{}, error) {
+		return ctx.generator().seq.next(), nil
+	}}
+	builtins["now"] = &function{name: "now", minArgs: 0, maxArgs: 2, call: now}
+}
+
+//deferred 包含uuid、seq等函数的计算字段的值，写入目标中的每一个位置时分别对表达式求值
+type deferred struct{}
+
+//volatileFunc 返回表达式中第一个每次调用结果都不同的函数名称，不存在时返回空字符串
+func volatileFunc(x expr) string {
+	name := ""
+	walkExpr(x, func(n expr) {
+		if c, ok := n.(*call); ok && c.fn.volatile && name == "" {
+			name = c.fn.name
+		}
+	})
+	return name
+}
+
+//now 返回当前时间，args为now([layout[, timezone]])的参数
+//没有声明layout时返回time.Time，写入目标时按照目标的layout输出，否则按照layout格式化，layout的写法与DataSpec.Layout相同
+func now(ctx *FuncContext, args []interface{}) (interface{}, error) {
+	t := ctx.generator().now()
+	if len(args) == 0 {
+		return t, nil
+	}
+	spec := &DataSpec{Layout: str(args[0])}
+	if len(args) == 2 {
+		spec.Timezone = str(args[1])
+		if _, err := loadLocation(spec.Timezone); err != nil {
+			return nil, err
+		}
+	}
+	return formatDateTime(t, spec), nil
+}
+
+//newUUIDv4 生成随机的UUID
+func newUUIDv4(g *generator) (string, error) {
+	var u [16]byte
+	if _, err := io.ReadFull(g.random, u[:]); err != nil {
+		return "", fmt.Errorf("uuid: %v", err)
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return formatUUID(u), nil
+}
+
+//newUUIDv7 生成以毫秒时间戳开头的UUID，按照生成时间排序
+func newUUIDv7(g *generator) (string, error) {
+	var u [16]byte
+	if _, err := io.ReadFull(g.random, u[6:]); err != nil {
+		return "", fmt.Errorf("uuidv7: %v", err)
+	}
+	// 前48位为大端序的毫秒时间戳
+	ms := uint64(g.now().UnixNano() / int64(time.Millisecond))
+	for i := 0; i < 6; i++ {
+		u[i] = byte(ms >> (40 - 8*i))
+	}
+	u[6] = u[6]&0x0f | 0x70
+	u[8] = u[8]&0x3f | 0x80
+	return formatUUID(u), nil
+}
+
+//formatUUID 按照8-4-4-4-12的形式输出UUID
+func formatUUID(u [16]byte) string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
@@ -0,0 +1,133 @@
+package datamapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//tokenKind 词法单元的类型
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenPunct
+)
+
+//token 词法单元
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+//puncts 支持的符号，较长的符号需要排在前面
+var puncts = []string{
+	"==", "!=", "<=", ">=", "&&", "||",
+	"(", ")", "[", "]", ",", ".", "+", "-", "*", "/", "%", "<", ">", "!", "?", ":",
+}
+
+//tokenize 将表达式切分为词法单元
+func tokenize(src string) ([]token, error) {
+	var res []token
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '_' || r == '$' || unicode.IsLetter(r):
+			start := i
+			for i < len(runes) && isIdentRune(runes[i]) {
+				i++
+			}
+			res = append(res, token{kind: tokenIdent, text: string(runes[start:i]), pos: start})
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(runes) && isNumberRune(runes, i) {
+				i++
+			}
+			text := string(runes[start:i])
+			if _, err := strconv.ParseFloat(text, 64); err != nil {
+				return nil, fmt.Errorf("invalid number %q at %d", text, start)
+			}
+			res = append(res, token{kind: tokenNumber, text: text, value: json.Number(text), pos: start})
+		case r == '"' || r == '\'':
+			start := i
+			s, n, err := unquote(runes[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%v at %d", err, start)
+			}
+			i += n
+			res = append(res, token{kind: tokenString, text: string(runes[start:i]), value: s, pos: start})
+		default:
+			matched := false
+			for _, p := range puncts {
+				if strings.HasPrefix(string(runes[i:]), p) {
+					res = append(res, token{kind: tokenPunct, text: p, pos: i})
+					i += len([]rune(p))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at %d", r, i)
+			}
+		}
+	}
+	return append(res, token{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+//isNumberRune 判断runes[i]是否属于数字字面量，支持小数与指数写法
+func isNumberRune(runes []rune, i int) bool {
+	r := runes[i]
+	switch {
+	case unicode.IsDigit(r), r == '.':
+		return true
+	case r == 'e' || r == 'E':
+		return true
+	case r == '+' || r == '-':
+		return i > 0 && (runes[i-1] == 'e' || runes[i-1] == 'E')
+	}
+	return false
+}
+
+//unquote 解析以单引号或双引号包围的字符串，返回字符串内容与消耗的字符数
+func unquote(runes []rune) (string, int, error) {
+	quote := runes[0]
+	var sb strings.Builder
+	for i := 1; i < len(runes); i++ {
+		switch runes[i] {
+		case quote:
+			return sb.String(), i + 1, nil
+		case '\\':
+			if i+1 >= len(runes) {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+			i++
+			switch runes[i] {
+			case 'n':
+				sb.WriteRune('\n')
+			case 't':
+				sb.WriteRune('\t')
+			case 'r':
+				sb.WriteRune('\r')
+			default:
+				sb.WriteRune(runes[i])
+			}
+		default:
+			sb.WriteRune(runes[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,159 @@
+package datamapper
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+//ErrKeyNotFound 转换表中不存在对应的key，并且没有声明默认值
+var ErrKeyNotFound = errors.New("key not found in lookup table")
+
+//LookupTable lookups中声明的转换表，用于将编码转换为另一种取值，例如 SUCCESS: 0
+//在transform中通过 lookup('表名') 调用，在表达式中通过 lookup(值, '表名') 调用，最后一个参数可以指定查找不到时的值
+type LookupTable struct {
+	//Values 内联声明的转换表，与Source同时存在时内联的值优先
+	Values map[string]interface{} `yaml:"values"`
+	//Source 通过Specification读取转换表时使用的名称，内容为yaml或json格式的对象，需要在编译前调用LoadLookups
+	Source string `yaml:"source"`
+	//Default 查找不到时使用的值，未填写时查找不到会报告ErrKeyNotFound
+	Default interface{} `yaml:"default"`
+
+	//loaded 记录Source中的转换表是否已经读取
+	loaded bool
+}
+
+//lookupTable 编译后的转换表
+type lookupTable struct {
+	name   string
+	values map[string]interface{}
+	def    interface{}
+}
+
+//LoadLookups 通过reader读取lookups中声明了Source的转换表
+func (d *DataDefine) LoadLookups(reader Specification) error {
+	for _, name := range sortedLookupKeys(d.Lookups) {
+		table := d.Lookups[name]
+		if table == nil || table.Source == "" {
+			continue
+		}
+		data, err := reader.Get(table.Source)
+		if err != nil {
+			return fmt.Errorf("lookup %s: %v", name, err)
+		}
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("lookup %s: %v", name, err)
+		}
+		// 空文件作为空的转换表
+		values := make(map[string]interface{})
+		switch doc := doc.(type) {
+		case nil:
+		case map[interface{}]interface{}:
+			for key, value := range doc {
+				values[fmt.Sprint(key)] = value
+			}
+		default:
+			return fmt.Errorf("lookup %s: %s is not a mapping", name, table.Source)
+		}
+		for key, value := range table.Values {
+			values[key] = value
+		}
+		table.Values = values
+		table.loaded = true
+	}
+	return nil
+}
+
+//lookups 编译DataDefine中声明的转换表
+func (c *compiler) lookups() (map[string]*lookupTable, error) {
+	res := make(map[string]*lookupTable, len(c.define.Lookups))
+	for _, name := range sortedLookupKeys(c.define.Lookups) {
+		table := c.define.Lookups[name]
+		if table == nil {
+			return nil, fmt.Errorf("lookup %s: table is empty", name)
+		}
+		if table.Source != "" && !table.loaded {
+			return nil, fmt.Errorf("lookup %s: table %s is not loaded, call LoadLookups first", name, table.Source)
+		}
+		t := &lookupTable{
+			name:   name,
+			values: make(map[string]interface{}, len(table.Values)),
+			def:    yamlValue(table.Default),
+		}
+		for key, value := range table.Values {
+			t.values[key] = yamlValue(value)
+		}
+		res[name] = t
+	}
+	return res, nil
+}
+
+//lookupNames 返回规则中以字面量引用的转换表名称
+func lookupNames(r *rule) []string {
+	var res []string
+	for _, s := range r.transforms {
+		if s.fn.name != "lookup" || len(s.args) == 0 {
+			continue
+		}
+		if name, ok := literalString(s.args[0]); ok {
+			res = append(res, name)
+		}
+	}
+	for _, x := range r.exprs() {
+		walkExpr(x, func(n expr) {
+			if c, ok := n.(*call); ok && c.fn.name == "lookup" && len(c.args) > 1 {
+				if name, ok := literalString(c.args[1]); ok {
+					res = append(res, name)
+				}
+			}
+		})
+	}
+	return res
+}
+
+func literalString(x expr) (string, bool) {
+	l, ok := x.(*literal)
+	if !ok {
+		return "", false
+	}
+	s, ok := l.value.(string)
+	return s, ok
+}
+
+//lookupValue 在转换表中查找value对应的值，args为lookup(值, 表名[, 默认值])的参数
+func lookupValue(ctx *FuncContext, args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	name := str(args[1])
+	var table *lookupTable
+	if ctx != nil {
+		table = ctx.lookups[name]
+	}
+	if table == nil {
+		return nil, fmt.Errorf("lookup table %s is not defined", name)
+	}
+	key := str(args[0])
+	if v, ok := table.values[key]; ok {
+		return v, nil
+	}
+	if len(args) == 3 {
+		return args[2], nil
+	}
+	if table.def != nil {
+		return table.def, nil
+	}
+	return nil, fmt.Errorf("lookup %s: %q: %w", name, key, ErrKeyNotFound)
+}
+
+func sortedLookupKeys(m map[string]*LookupTable) []string {
+	res := make([]string, 0, len(m))
+	for key := range m {
+		res = append(res, key)
+	}
+	sort.Strings(res)
+	return res
+}
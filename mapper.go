@@ -1,13 +1,11 @@
 package datamapper
 
 import (
-	"fmt"
+	stdjson "encoding/json"
 	"io/ioutil"
 	"log"
 	"os"
 	"reflect"
-	"strconv"
-	"strings"
 
 	"github.com/the-prophet1/datamapper/mapping/json"
 	"github.com/the-prophet1/datamapper/mapping/xml"
@@ -85,24 +83,106 @@ func (FileReader) Get(name string) ([]byte, error) {
 
 //DataSpec 数据的规格说明
 type DataSpec struct {
+	//Type 数据的种类，支持simple、complex、map、oneOf以及any，any保留原始的值，可以是对象、数组或者简单类型
 	Type     string `yaml:"type"`
 	TypeRef  string `yaml:"typeRef"`
 	Multiple string `yaml:"multiple"`
+	//Nullable 为true时允许值为null，作为目标时未被映射的字段输出null而不是零值
+	Nullable string `yaml:"nullable"`
+	//Scale typeRef为decimal时保留的小数位数，未填写时保留输入的精度
+	Scale *int `yaml:"scale"`
+	//Layout typeRef为datetime时的时间格式，支持Go的时间格式、RFC3339等格式名称以及epoch_s/epoch_ms/epoch_us/epoch_ns
+	//作为源数据时用于解析输入，作为目标数据时用于格式化输出，默认为RFC3339Nano
+	Layout string `yaml:"layout"`
+	//Timezone typeRef为datetime时使用的时区，支持IANA时区名称、Local、UTC以及+08:00形式的固定偏移，默认为UTC
+	Timezone string `yaml:"timezone"`
+	//Default 简单类型字段的默认值，作为源数据时用于替代输入中缺失的字段，作为目标数据时用于没有被映射的字段
+	//数组字段的默认值需要写成列表
+	Default interface{} `yaml:"default"`
+	//Required 为true时字段必须存在：源数据中缺失该字段或者目标字段没有被映射时报告ErrRequired，声明了default时不会报告
+	Required string `yaml:"required"`
+	//OneOf type为oneOf时候选的复杂类型名称，源数据按照Discriminator或者结构选择其中之一
+	OneOf []string `yaml:"oneOf"`
+	//Discriminator 对象中用于区分类型的字段路径，例如 type，未填写时选择第一个包含对象所有字段的类型
+	Discriminator string `yaml:"discriminator"`
+	//Variants Discriminator的取值与类型名称的对应关系，未列出的取值直接作为类型名称
+	Variants map[string]string `yaml:"variants"`
 	//当输入的Multiple=true时，用于实时计算输入的数据的个数
+	//
+	//Deprecated: 该字段会在并发转换时产生数据竞争，datamapper已不再写入该字段
 	Count int `yaml:"-"`
 }
 
 //ComplexDefine 复杂数据的声明结构
 type ComplexDefine map[string]*DataSpec
 
+//MapperRule mapper中的一条映射规则
+//在yaml中既可以直接写目标路径，也可以写成包含target与transform的对象
+type MapperRule struct {
+	//Target 目标数据中的路径
+	Target string `yaml:"target"`
+	//Transform 写入目标前依次执行的函数，例如 [trim, upper, "padLeft(8, '0')"]
+	Transform []string `yaml:"transform"`
+	//When 映射的条件表达式，例如 properties.desc.type == "DOUBLE"
+	//路径经过对象数组时按元素分别求值，条件不成立的元素不写入目标，写入同一目标的多条规则可以用条件选择来源
+	When string `yaml:"when"`
+	//Fallback 条件不成立时写入的表达式，例如 properties.desc.defaultVal 或 'N/A'，未填写时跳过该值
+	Fallback string `yaml:"fallback"`
+	//Pivot 将源数据中的对象数组转换为以Key为键、Value为值的对象，目标需要为map字段
+	Pivot *PivotRule `yaml:"pivot"`
+	//Unpivot 将源数据中的对象转换为对象数组，每个键值对写入目标数组元素中的Key与Value路径
+	Unpivot *PivotRule `yaml:"unpivot"`
+	//Rename 通配符规则 data.*: va.* 中对字段名依次执行的函数，例如 [camelCase, "trimPrefix('dev_')"]
+	Rename []string `yaml:"rename"`
+}
+
+//PivotRule 行列转换时元素中作为key与value的路径
+type PivotRule struct {
+	//Key 元素中作为对象key的路径，例如 name
+	Key string `yaml:"key"`
+	//Value 元素中作为对象value的路径，例如 val
+	Value string `yaml:"value"`
+}
+
+//UnmarshalYAML 兼容只写目标路径的写法
+func (r *MapperRule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var target string
+	if err := unmarshal(&target); err == nil {
+		r.Target = target
+		return nil
+	}
+	type plain MapperRule
+	return unmarshal((*plain)(r))
+}
+
 //DataDefine 数据定义结构体
 type DataDefine struct {
-	SourceType string                   `yaml:"sourceType"`
-	TargetType string                   `yaml:"targetType"`
-	Source     ComplexDefine            `yaml:"source"`
-	Target     ComplexDefine            `yaml:"target"`
-	Mapper     map[string]string        `yaml:"mapper"`
-	Complex    map[string]ComplexDefine `yaml:"complex"`
+	SourceType string        `yaml:"sourceType"`
+	TargetType string        `yaml:"targetType"`
+	Source     ComplexDefine `yaml:"source"`
+	Target     ComplexDefine `yaml:"target"`
+	//Mapper 映射规则，key为源路径
+	//
+	//不兼容变更：早期版本中该字段为map[string]string，引入transform等规则后改为map[string]*MapperRule。
+	//yaml中只写目标路径的写法仍然可以解析，但直接读写该字段的Go代码需要修改后才能编译：
+	//写入 d.Mapper[source] = target 改为 d.SetMapper(source, target)，读取 d.Mapper[source] 改为 d.Mapper[source].Target
+	Mapper map[string]*MapperRule `yaml:"mapper"`
+	//Computed 计算字段，key为目标路径，value为根据源数据求值的表达式，例如 data.voltage * data.current
+	Computed map[string]string        `yaml:"computed"`
+	Complex  map[string]ComplexDefine `yaml:"complex"`
+	//Params 运行时参数的声明，参数通过WithParams传入，在mapper与表达式中以$params.tenant的形式引用
+	//声明为required的参数缺失时报告ErrRequired，没有声明时传入的参数原样使用
+	Params ComplexDefine `yaml:"params"`
+	//Constants 常量，key为目标路径，value为写入的值，可以是简单类型、数组或者对象，与映射的值一样按照目标的规格转换
+	Constants map[string]interface{} `yaml:"constants"`
+	//Lookups 转换表，key为表名
+	Lookups map[string]*LookupTable `yaml:"lookups"`
+	//Passthrough 为true时将mapper没有使用的输入字段复制到输出中相同的路径，mapper中的规则优先
+	Passthrough *PassthroughRule `yaml:"passthrough"`
+	//seq 表达式中seq函数使用的序列，由该DataDefine编译得到的所有Plan共享
+	seq sequence
+	//raw 生成DataDefine的原始yaml，用于在Validate时定位问题所在的行
+	raw []byte
 }
 
 //GenerateDataDefine 根据输入的yaml数据流生成对应的DataDefine
@@ -112,9 +192,18 @@ func GenerateDataDefine(data []byte) (*DataDefine, error) {
 		logger.Warn("parse data define error:", err.Error())
 		return nil, err
 	}
+	define.raw = data
 	return &define, nil
 }
 
+//SetMapper 添加一条只有目标路径的映射规则，代替早期版本中 d.Mapper[source] = target 的写法
+func (d *DataDefine) SetMapper(source, target string) {
+	if d.Mapper == nil {
+		d.Mapper = make(map[string]*MapperRule)
+	}
+	d.Mapper[source] = &MapperRule{Target: target}
+}
+
 //IsNumber 判断数据规格是否为number
 func (d *DataSpec) IsNumber() bool {
 	return d.TypeRef == "number"
@@ -125,518 +214,118 @@ func (d *DataSpec) IsString() bool {
 	return d.TypeRef == "string"
 }
 
-//IsComplex 判断数据规格是否为complex
-func (d *DataSpec) IsComplex() bool {
-	return d.Type == "complex"
+//IsBoolean 判断数据规格是否为boolean
+func (d *DataSpec) IsBoolean() bool {
+	return d.TypeRef == "boolean"
 }
 
-//IsSimple 判断数据规格是否为simple
-func (d *DataSpec) IsSimple() bool {
-	return d.Type == "simple"
+//IsInteger 判断数据规格是否为integer，integer使用int64表示
+func (d *DataSpec) IsInteger() bool {
+	return d.TypeRef == "integer"
 }
 
-//IsArray 判断数据规格是否为array
-func (d *DataSpec) IsArray() bool {
-	return d.Multiple == "true"
+//IsDecimal 判断数据规格是否为decimal，decimal使用json.Number表示，不会经过float64转换
+func (d *DataSpec) IsDecimal() bool {
+	return d.TypeRef == "decimal"
 }
 
-//parseSourceType 根据sourceType找到对应的handle函数解析input数据并返回
-func parseSourceType(sourceType string, input []byte) (map[string]interface{}, error) {
-	var inputMap map[string]interface{}
-	var allDefines []string
-	for define, handle := range SourceTypeDefine {
-		allDefines = append(allDefines, define)
-		if define == sourceType {
-			if err := handle(input, &inputMap); err != nil {
-				return nil, err
-			}
-			return inputMap, nil
-		}
-	}
-	return nil, fmt.Errorf("sourceType not any of them: %v", allDefines)
+//IsDateTime 判断数据规格是否为datetime
+func (d *DataSpec) IsDateTime() bool {
+	return d.TypeRef == "datetime"
 }
 
-func parseTargetType(targetType string, output interface{}) ([]byte, error) {
-	var allDefines []string
-	for define, handle := range TargetTypeDefine {
-		allDefines = append(allDefines, define)
-		if define == targetType {
-			if outputData, err := handle(output); err != nil {
-				return nil, err
-			} else {
-				return outputData, nil
-			}
-
-		}
-	}
-	return nil, fmt.Errorf("sourceType not any of them: %v", allDefines)
+//IsNullable 判断数据规格是否允许为null
+func (d *DataSpec) IsNullable() bool {
+	return d.Nullable == "true"
 }
 
-// To 将输入数据转换为源数据，再将源数据转换为目标数据
-func (d *DataDefine) To(input []byte) ([]byte, error) {
-	// 获取数据的map
-	inputMap, err := parseSourceType(d.SourceType, input)
-	if err != nil {
-		logger.Warn("parse input data error: ", err)
-		return nil, err
-	}
-
-	sourceMap := d.ParseSource(d.Source, inputMap)
-	targetMap := d.GenerateMap(d.Target)
-	d.Mapping(sourceMap, targetMap)
+//IsRequired 判断数据规格是否为必填
+func (d *DataSpec) IsRequired() bool {
+	return d.Required == "true"
+}
 
-	return parseTargetType(d.TargetType, targetMap)
+//IsMap 判断数据规格是否为map，map的key由数据决定，value的类型由typeRef声明
+func (d *DataSpec) IsMap() bool {
+	return d.Type == "map"
 }
 
-func getSourceData(sourceMap map[string]interface{}, paths []string) interface{} {
-	var res interface{}
-	if len(paths) == 0 {
-		return nil
-	}
-	//从path中获取对应的value值
-	val, ok := sourceMap[paths[0]]
-	if !ok {
-		// 搜寻路径中不存在对应的值
-		return res
-	}
+//IsOneOf 判断数据规格是否为oneOf
+func (d *DataSpec) IsOneOf() bool {
+	return d.Type == "oneOf"
+}
 
-	switch val := val.(type) {
-	case number, string, []number, []string:
-		res = val
-	case map[string]interface{}:
-		res = getSourceData(val, paths[1:])
-	case []map[string]interface{}:
-		sli := make([]interface{}, 0)
-		for _, m := range val {
-			sli = append(sli, getSourceData(m, paths[1:]))
-		}
-		res = sli
-	}
+//IsAny 判断数据规格是否为any，any类型的值不做转换，原样写入目标
+func (d *DataSpec) IsAny() bool {
+	return d.Type == "any"
+}
 
-	return res
+//IsComplex 判断数据规格是否为complex
+func (d *DataSpec) IsComplex() bool {
+	return d.Type == "complex"
 }
 
-func getTargetData(targetMap map[string]*interface{}, paths []string, length int) *interface{} {
-	var res *interface{}
-	if len(paths) == 0 {
-		return nil
-	}
+//IsSimple 判断数据规格是否为simple
+func (d *DataSpec) IsSimple() bool {
+	return d.Type == "simple"
+}
 
-	val, ok := targetMap[paths[0]]
-	if !ok {
-		// 搜寻路径中不存在对应的值
-		return res
-	}
+//IsArray 判断数据规格是否为array
+func (d *DataSpec) IsArray() bool {
+	return d.Multiple == "true"
+}
 
-	switch (*val).(type) {
-	case number, string, []number, []string:
-		res = val
-	case map[string]*interface{}:
-		res = getTargetData((*val).(map[string]*interface{}), paths[1:], length)
-	case []map[string]*interface{}:
-		if length == 0 {
-			// 将val置为长度为0的切片
-			*val = make([]map[string]*interface{}, 0)
-		}
-		// 扩展对象数组
-		sli := (*val).([]map[string]*interface{})
-		if len(sli) != length {
-			for i := 0; i < length-1; i++ {
-				sli = append(sli, Clone(sli[0]).(map[string]*interface{}))
-			}
-			// 将拓展对象数组复制给targetMap
-			*val = sli
-		}
-		slip := make([]*interface{}, 0)
-		for _, m := range sli {
-			slip = append(slip, getTargetData(m, paths[1:], length))
-		}
-		var iface interface{} = slip
-		res = &iface
+// To 将输入数据转换为源数据，再将源数据转换为目标数据
+//每次调用都会重新编译DataDefine，需要重复使用同一份规格时应使用Compile得到的Plan
+func (d *DataDefine) To(input []byte, opts ...Option) ([]byte, error) {
+	plan, err := Compile(d)
+	if err != nil {
+		logger.Warn("compile data define error: ", err)
+		return nil, err
 	}
-
-	return res
+	return plan.Transform(input, opts...)
 }
 
 //Clone 复制的source的机构与数据并返回一个新的对象
+//使用标准库的encoding/json复制，interface{}中的数值与之前一样为float64，而不是转换时使用的json.Number
 func Clone(source interface{}) interface{} {
 	typ := reflect.TypeOf(source)
 	if typ.Kind() == reflect.Ptr { //如果是指针类型
-		typ = typ.Elem()                              //获取源实际类型(否则为指针类型)
-		dst := reflect.New(typ).Elem()                //创建对象
-		b, _ := json.Marshal(source)                  //导出json
-		_ = json.Unmarshal(b, dst.Addr().Interface()) //json序列化
-		return dst.Addr().Interface()                 //返回指针
+		typ = typ.Elem()                                 //获取源实际类型(否则为指针类型)
+		dst := reflect.New(typ).Elem()                   //创建对象
+		b, _ := stdjson.Marshal(source)                  //导出json
+		_ = stdjson.Unmarshal(b, dst.Addr().Interface()) //json序列化
+		return dst.Addr().Interface()                    //返回指针
 	}
-	dst := reflect.New(typ).Elem()                //创建对象
-	b, _ := json.Marshal(source)                  //导出json
-	_ = json.Unmarshal(b, dst.Addr().Interface()) //json序列化
-	return dst.Interface()                        //返回值
+	dst := reflect.New(typ).Elem()                   //创建对象
+	b, _ := stdjson.Marshal(source)                  //导出json
+	_ = stdjson.Unmarshal(b, dst.Addr().Interface()) //json序列化
+	return dst.Interface()                           //返回值
 }
 
 //Mapping 将sourceMap的数据值映射到targetMap
 //targetMap的value值需要使用指针类型，用于修改指向的interface{}结果值，从而改变value的值。
 func (d *DataDefine) Mapping(sourceMap map[string]interface{}, targetMap map[string]*interface{}) {
-
-	for source, target := range d.Mapper {
-		sourcePaths := strings.Split(source, ".")
-
-		sourceData := getSourceData(sourceMap, sourcePaths)
-
-		// 当sourceData为[]interface{}，根据类型将其转为[]number 或[]string
-		switch sourceData.(type) {
-		case []interface{}:
-			sli := sourceData.([]interface{})
-			if len(sli) > 0 {
-				switch sli[0].(type) {
-				case string:
-					res := make([]string, 0)
-					for _, i := range sli {
-						res = append(res, i.(string))
-					}
-					sourceData = res
-				case number:
-					res := make([]number, 0)
-					for _, i := range sli {
-						res = append(res, i.(number))
-					}
-					sourceData = res
-				}
-			}
-		}
-
-		length := 0
-		switch sourceData := sourceData.(type) {
-		case []number:
-			length = len(sourceData)
-		case []string:
-			length = len(sourceData)
-		}
-
-		targetPaths := strings.Split(target, ".")
-		targetData := getTargetData(targetMap, targetPaths, length)
-		sourceValue := reflect.Indirect(reflect.ValueOf(sourceData))
-		targetValue := reflect.Indirect(reflect.ValueOf(*targetData))
-		// 此时sourceData和targetData必须为简单类型
-		if sourceValue.Kind() == targetValue.Kind() && (sourceValue.Kind() == reflect.String || sourceValue.Kind() == reflect.Float64) {
-			*targetData = sourceData
-		} else if sourceValue.Kind() == targetValue.Kind() && sourceValue.Kind() == reflect.Slice {
-			//都是切片的情况
-			if sourceValue.Type().Elem().Kind() == targetValue.Type().Elem().Kind() { //类型相同
-				*targetData = sourceData
-			} else if sourceValue.Type().Elem().Kind() == reflect.Float64 &&
-				targetValue.Type().Elem().Kind() == reflect.String {
-				sli := make([]string, 0)
-				for _, f := range sourceData.([]number) {
-					sf := strconv.FormatFloat(f, 'f', -1, 64)
-					sli = append(sli, sf)
-				}
-				*targetData = sli
-			} else if sourceValue.Type().Elem().Kind() == reflect.String &&
-				targetValue.Type().Elem().Kind() == reflect.Float64 {
-				sli := make([]number, 0)
-				for _, s := range sourceData.([]string) {
-					sf, _ := strconv.ParseFloat(s, 64)
-					sli = append(sli, sf)
-				}
-				*targetData = sli
-			} else if targetValue.Type().Elem().Kind() == reflect.Ptr {
-				sf, sourceOk := sourceData.([]number)
-				t, targetOk := (*targetData).([]*interface{})
-				// 当source为[]number,target为[]*interface{}
-				if sourceOk && targetOk {
-					for i, f := range sf {
-						*t[i] = f
-					}
-				}
-
-				ss, sourceOk := sourceData.([]string)
-				t, targetOk = (*targetData).([]*interface{})
-				// 当source为[]string,target为[]*interface{}
-				if sourceOk && targetOk {
-					for i, f := range ss {
-						*t[i] = f
-					}
-				}
-			}
-		} else if sourceValue.Kind() == reflect.Float64 && targetValue.Kind() == reflect.String {
-			*targetData = strconv.FormatFloat(sourceData.(number), 'f', -1, 64)
-		} else if sourceValue.Kind() == reflect.String && targetValue.Kind() == reflect.Float64 {
-			*targetData, _ = strconv.ParseFloat(sourceData.(string), 64)
-		}
+	c := newCompiler(d)
+	target := c.root(d.Target)
+	rules, err := c.rules()
+	if err != nil {
+		logger.Warn(err)
 	}
+	m := newMapping()
+	m.gen.seq = &d.seq
+	if m.lookups, err = c.lookups(); err != nil {
+		logger.Warn(err)
+	}
+	m.mapRules(rules, sourceMap, target, targetMap)
 }
 
 //GenerateMap 根据complexDefine定义生成对应的map[string]*interface
 //对应生成的map，如果存在数组则会自动包含一个元素
 func (d *DataDefine) GenerateMap(complexDefine ComplexDefine) map[string]*interface{} {
-	res := make(map[string]*interface{})
-	for key, def := range complexDefine {
-		if def.IsArray() {
-			if def.IsComplex() {
-				val := make([]map[string]*interface{}, 0)
-				val = append(val, d.GenerateMap(d.Complex[def.TypeRef]))
-				var vals interface{} = val
-				res[key] = &vals
-			} else if def.IsSimple() {
-				if def.IsNumber() {
-					val := make([]number, 0)
-					val = append(val, number(0))
-					var vals interface{} = val
-					res[key] = &vals
-				}
-				if def.IsString() {
-					val := make([]string, 0)
-					val = append(val, "")
-					var vals interface{} = val
-					res[key] = &vals
-				}
-			}
-		} else {
-			if def.IsComplex() {
-				var val interface{} = d.GenerateMap(d.Complex[def.TypeRef])
-				res[key] = &val
-			} else if def.IsSimple() {
-				if def.IsNumber() {
-					var val interface{} = number(0)
-					res[key] = &val
-				}
-				if def.IsString() {
-					var val interface{} = ""
-					res[key] = &val
-				}
-			}
-		}
-	}
-	return res
+	return generateMap(newCompiler(d).root(complexDefine))
 }
 
 //ParseSource 根据数据定义将input转换为由ComplexDefine定义的output
 func (d *DataDefine) ParseSource(complexDefine ComplexDefine, inputMap map[string]interface{}) map[string]interface{} {
-	res := make(map[string]interface{})
-
-	// 从复合类型定义中获取各个字段的定义
-	for key, def := range complexDefine {
-		// 从复合类型的名称取出inputMap的数据
-		inValue, ok := inputMap[key]
-		if !ok { //不存在则跳过
-			continue
-		}
-		//判断所需是否为数组
-		if def.IsArray() {
-			// 如果input也是数组
-			if typ := reflect.TypeOf(inValue); typ.Kind() == reflect.Slice {
-				if def.IsComplex() {
-					switch inValue := inValue.(type) {
-					case []map[string]interface{}:
-						sliMap := inValue
-						slim := make([]map[string]interface{}, 0)
-						for _, m := range sliMap {
-							e := d.ParseSource(d.Complex[def.TypeRef], m)
-							slim = append(slim, e)
-						}
-						def.Count = len(slim)
-						res[key] = slim
-					case []interface{}:
-						sliMap := inValue
-						slim := make([]map[string]interface{}, 0)
-						for _, v := range sliMap {
-							m, ok := v.(map[string]interface{})
-							if !ok {
-								continue
-							}
-							e := d.ParseSource(d.Complex[def.TypeRef], m)
-							slim = append(slim, e)
-						}
-						def.Count = len(slim)
-						res[key] = slim
-					default:
-						logger.Warn("can't parse value type: ", reflect.TypeOf(inValue).String())
-					}
-				}
-				if def.IsSimple() { // 此时数据类型为简单类型
-					// 将[]interface{}转换为对应的类型切片
-					is, ok := inValue.([]interface{})
-					if ok && len(is) > 0 {
-						elemTyp := reflect.TypeOf(is[0]).Kind()
-
-						if elemTyp == reflect.String {
-							strs := make([]string, 0)
-							for _, i := range is {
-								strs = append(strs, i.(string))
-							}
-							def.Count = len(strs)
-							inValue = strs
-						} else if elemTyp == reflect.Float64 {
-							fs := make([]number, 0)
-							for _, i := range is {
-								fs = append(fs, i.(number))
-							}
-							def.Count = len(fs)
-							inValue = fs
-						}
-					}
-
-					switch inValue := inValue.(type) {
-					case []string:
-						if def.IsString() { // 类型匹配，直接赋值
-							res[key] = inValue
-						}
-						if def.IsNumber() {
-							// 类型不匹配，尝试将string转为float
-							slif := make([]number, 0)
-							for _, s := range inValue {
-								f, err := strconv.ParseFloat(s, 64)
-								if err != nil {
-									logger.Warn(err)
-								}
-								slif = append(slif, f)
-							}
-							res[key] = slif
-						}
-					case []number:
-						if def.IsNumber() {
-							res[key] = inValue
-						}
-						if def.IsString() {
-							// 类型不匹配，尝试将float转为string
-							slis := make([]string, 0)
-							for _, f := range inValue {
-								s := strconv.FormatFloat(f, 'f', -1, 64)
-								slis = append(slis, s)
-							}
-							res[key] = slis
-						}
-					default:
-						logger.Warn("can't parse value type: ", reflect.TypeOf(inValue).String())
-					}
-				}
-			} else {
-				// 此时输入为对象或简单数据类型
-				if def.IsComplex() {
-					switch inValue := inValue.(type) {
-					case map[string]interface{}:
-						m := inValue
-						c := d.ParseSource(d.Complex[def.TypeRef], m)
-						sli := make([]map[string]interface{}, 0)
-						res[key] = append(sli, c)
-					default:
-						logger.Warn("can't parse value type: ", reflect.TypeOf(inValue).String())
-					}
-				}
-				if def.IsSimple() {
-					switch inValue := inValue.(type) {
-					case string:
-						if def.IsString() {
-							res[key] = []string{inValue}
-						}
-						if def.IsNumber() {
-							f, err := strconv.ParseFloat(inValue, 64)
-							if err != nil {
-								logger.Warn(err)
-							}
-							res[key] = []number{f}
-						}
-					case number:
-						if def.IsNumber() {
-							res[key] = []number{inValue}
-						}
-						if def.IsString() {
-							s := strconv.FormatFloat(inValue, 'f', -1, 64)
-							res[key] = []string{s}
-						}
-					default:
-						logger.Warn("can't parse value type: ", reflect.TypeOf(inValue).String())
-					}
-				}
-			}
-		} else {
-			if typ := reflect.TypeOf(inValue); typ.Kind() == reflect.Slice {
-				if def.IsComplex() {
-					switch inValue := inValue.(type) {
-					case []map[string]interface{}:
-						slim := inValue
-						if len(slim) == 0 {
-							res[key] = nil
-						} else {
-							res[key] = d.ParseSource(d.Complex[def.TypeRef], slim[0])
-						}
-					default:
-						logger.Warn("can't parse value type: ", reflect.TypeOf(inValue).String())
-					}
-				}
-				if def.IsSimple() {
-					switch inValue := inValue.(type) {
-					case []string:
-						strs := inValue
-						if len(strs) == 0 {
-							res[key] = nil
-						} else {
-							if def.IsString() {
-								res[key] = strs[0]
-							}
-							if def.IsNumber() {
-								f, err := strconv.ParseFloat(strs[0], 64)
-								if err != nil {
-									logger.Warn(err)
-								}
-								res[key] = f
-							}
-						}
-					case []number:
-						floats := inValue
-						if len(floats) == 0 {
-							res[key] = nil
-						} else {
-							if def.IsNumber() {
-								res[key] = floats[0]
-							}
-							if def.IsString() {
-								s := strconv.FormatFloat(floats[0], 'f', -1, 64)
-								res[key] = s
-							}
-						}
-					default:
-						logger.Warn("can't parse value type: ", reflect.TypeOf(inValue).String())
-					}
-				}
-			} else {
-				if def.IsComplex() {
-					switch inValue := inValue.(type) {
-					case map[string]interface{}:
-						c := d.ParseSource(d.Complex[def.TypeRef], inValue)
-						res[key] = c
-					default:
-						logger.Warn("can't parse value type: ", reflect.TypeOf(inValue).String())
-					}
-				}
-				if def.IsSimple() {
-					switch inValue := inValue.(type) {
-					case string:
-						if def.IsString() {
-							res[key] = inValue
-						}
-						if def.IsNumber() {
-							f, err := strconv.ParseFloat(inValue, 64)
-							if err != nil {
-								logger.Warn(err)
-							}
-							res[key] = f
-						}
-					case number:
-						if def.IsNumber() {
-							res[key] = inValue
-						}
-						if def.IsString() {
-							s := strconv.FormatFloat(inValue, 'f', -1, 64)
-							res[key] = s
-						}
-					default:
-						logger.Warn("can't parse value type: ", reflect.TypeOf(inValue).String())
-					}
-				}
-			}
-		}
-	}
-	return res
+	return newMapping().parseSource(newCompiler(d).root(complexDefine), inputMap, "", 0)
 }
@@ -1,10 +1,17 @@
 package datamapper
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -65,6 +72,102 @@ var mapperTest = []TestStruct{
 		`{"msg":"成功","headers":{"qos":1,"oneofCase":5,"token":"kCBQLBlvOp+9fOsRWKN3VD6V5DSNgnpNnU2U1M6cOYg="},"code":"SUCCESS","fromMessageId":"","messageId":"f09856be6ae947a79ca21d24a33e7239","properties":[],"timestamp":1642757411915}`,
 		`{"id":"f09856be6ae947a79ca21d24a33e7239","code":"SUCCESS","msg":"成功","datas":[]}`,
 	},
+	{
+		"test7",
+		Spec("./test/json2json/test7.yaml"),
+		`{"id":7,"online":"1","flags":[true,false,"true"],"count":"12","ratio":null,"level":3}`,
+		`{"id":"7","online":true,"flags":[1,0,1],"count":12,"ratio":null,"level":3,"remark":null}`,
+	},
+	{
+		"test9",
+		Spec("./test/json2json/test9.yaml"),
+		`{"time":"1642757405418","created":"2022/01/21 17:30:05","updated":["2022-01-21T09:30:05.418Z","2022-01-21T17:30:05+08:00"]}`,
+		`{"time":"2022-01-21 17:30:05.418","created":1642757405,"updated":[1642757405418,1642757405000],"deleted":null}`,
+	},
+	{
+		"test10",
+		Spec("./test/json2json/test10.yaml"),
+		`{"code":"SUCCESS","messageId":"f09856be6ae947a79ca21d24a33e7239","properties":[{"val":"0.0712","name":" cpu ","unit":"%"},{"val":"0.1","name":"mem","unit":"MB"}]}`,
+		`{"id":"f09856be","code":"SUCCESS","datas":[{"name":"CPU","val":7.1,"unit":"**%"},{"name":"MEM","val":10,"unit":"*MB"}]}`,
+	},
+	{
+		"test11",
+		Spec("./test/json2json/test11.yaml"),
+		`{"id":"dev1","data":{"voltage":220,"current":10},"properties":[{"name":"cpu","val":0.0712},{"name":"mem","val":0.25}]}`,
+		`{"id":"dev1","power":2200,"level":"high","total":0.3212,"datas":[{"label":"dev1-CPU","percent":7.1},{"label":"dev1-MEM","percent":25}]}`,
+	},
+	{
+		"test13",
+		Spec("./test/json2json/test13.yaml"),
+		`{"id":"dev1","retry":0}`,
+		`{"id":"dev1","level":"info","retry":0,"tags":["default"],"ratio":1.00,"status":"unknown"}`,
+	},
+	{
+		"test14",
+		Spec("./test/json2json/test14.yaml"),
+		`{"primary":null,"secondary":"ops@example.com","properties":[{"name":"cpu","val":"0.5","desc":{"type":"DOUBLE"}},{"name":"state","val":"on","desc":{"type":"STRING"}}]}`,
+		`{"contact":"ops@example.com","datas":[{"name":"cpu","val":50},{"name":"n/a","val":null}]}`,
+	},
+	{
+		"test16",
+		Spec("./test/json2json/test16.yaml"),
+		`{"messageId":"f09856be","properties":[{"val":"7.00","name":"CPU使用率","desc":{"unit":"%","quality":0}},{"val":"10.00","name":"内存使用率","desc":{"unit":"%","quality":2}},{"val":"1","name":"温度","desc":{"unit":"C","quality":1}}]}`,
+		`{"id":"f09856be/2","cpu":7,"memory":10,"disk":null,"alarms":[{"name":"内存使用率","quality":2},{"name":"温度","quality":1}]}`,
+	},
+	{
+		"test17",
+		Spec("./test/json2json/test17.yaml"),
+		`{"id":"dev1","code":"A","readings":[1,2,3,4],"properties":[{"name":"a","val":1},{"name":"b","val":2},{"name":"c","val":3}]}`,
+		`{"first":"a","last":3,"latest":4,"window":[2,3],"total":5,"slots":["","dev1"],"datas":[{"name":"b","code":"A"},{"name":"c","code":""}]}`,
+	},
+	{
+		"test18",
+		Spec("./test/json2json/test18.yaml"),
+		`{"id":"dev1","properties":[{"name":"voltage","val":"220"},{"name":"current","val":"10"}],"attrs":{"model":"x1","vendor":"acme"}}`,
+		`{"id":"dev1","metrics":{"current":10,"voltage":220},"datas":[{"name":"model","val":"X1"},{"name":"vendor","val":"ACME"}]}`,
+	},
+	{
+		"test19",
+		Spec("./test/json2json/test19.yaml"),
+		`{"area":"north","site":"s1","zone":"z1","devices":[{"id":"d1","channels":[{"name":"a","value":1,"samples":[1,2]},{"name":"b","value":2,"samples":[]},{"name":"c","value":3,"samples":[3]}]},{"id":"d2","channels":[]},{"id":"d3","channels":[{"name":"x","value":9,"samples":[7,8,9]}]}]}`,
+		`{"site":"s1","devices":[{"id":"d1","site":"s1","channels":[{"name":"a","value":1,"samples":[1,2],"device":"d1","area":"north"},{"name":"b","value":2,"samples":[],"device":"d1","area":"north"},{"name":"c","value":3,"samples":[3],"device":"d1","area":"north"}],"readings":[{"area":"z1","value":2},{"area":"z1","value":4},{"area":"z1","value":6}]},{"id":"d2","site":"s1","channels":[],"readings":[]},{"id":"d3","site":"s1","channels":[{"name":"x","value":9,"samples":[7,8,9],"device":"d3","area":"north"}],"readings":[{"area":"z1","value":18}]}]}`,
+	},
+	{
+		"test21",
+		Spec("./test/json2json/test21.yaml"),
+		`{"sensors":{"t2":{"temp":35,"unit":"C"},"t1":{"temp":21.5,"unit":"C"},"t3":{"temp":99,"unit":"F"}},"labels":{"site":"north","floor":"2"}}`,
+		`{"readings":[{"id":"t1","temp":21.5,"unit":"C"},{"id":"t2","temp":35,"unit":"C"},{"id":"t3","temp":99,"unit":"F"}],"hot":["t2","t3"],"labelKeys":["floor","site"],"labelValues":["2","north"],"first":21.5,"latest":{"t1":{"id":"","temp":21.5,"unit":"C"},"t2":{"id":"","temp":35,"unit":"C"},"t3":{"id":"","temp":99,"unit":"F"}}}`,
+	},
+	{
+		"test22",
+		Spec("./test/json2json/test22.yaml"),
+		`{"messages":[{"type":"temp","value":21.5},{"type":"alarm","code":"E1","level":"high"},{"type":"temp","value":30}],"payload":{"content":"hello"}}`,
+		`{"events":[{"kind":"tempMsg","value":21.5,"code":""},{"kind":"alarmMsg","value":0,"code":"E1"},{"kind":"tempMsg","value":30,"code":""}],"alarms":["high"],"detail":{"content":"hello"},"detailKind":"text"}`,
+	},
+	{
+		"test23",
+		Spec("./test/json2json/test23.yaml"),
+		`{"headers":{"qos":1,"token":"abc","route":["a","b"]},"extra":{"device":{"id":"d1","port":8080,"vendor":"acme"},"flags":[true,null]},"code":"OK","properties":[{"name":"cpu","val":7},{"name":"mem","val":10}]}`,
+		`{"headers":{"qos":1,"token":"abc","route":["a","b"]},"extra":{"device":{"id":"d1","port":8080,"vendor":"acme"},"flags":[true,null]},"token":"abc","code":"OK","props":[{"name":"cpu","val":7},{"name":"mem","val":10}],"missing":null,"device":{"id":"d1","port":8080}}`,
+	},
+	{
+		"test24",
+		Spec("./test/json2json/test24.yaml"),
+		`{"id":"x","code":"7","data":{"voltage":220,"current":10,"extra":{"a":1,"debug":true}},"meta":{"trace":"t"},"secret":"s","list":[1,{"b":2}]}`,
+		`{"deviceId":"x","va":{"V":220},"code":7,"data":{"current":10,"extra":{"a":1}},"meta":{"trace":"t"},"list":[1,{"b":2}]}`,
+	},
+	{
+		"test25",
+		Spec("./test/json2json/test25.yaml"),
+		`{"id":"m1","data":{"voltage":220,"current":10,"power":2200,"power_factor":0.95,"dev_serial_no":"SN01"}}`,
+		`{"id":"m1","va":{"voltage":220,"current":10,"power":0,"watts":2200,"powerFactor":0.95,"serialNo":"SN01"}}`,
+	},
+	{
+		"test26",
+		Spec("./test/json2json/test26.yaml"),
+		`{"id":"dev1","properties":[{"name":"a"},{"name":"b"}]}`,
+		`{"id":"dev1","schemaVersion":2,"source":"gateway-A","tags":["iot","edge"],"gateway":{"name":"gw-1","port":8080},"extra":{"flags":[true,false]},"datas":[{"name":"a","origin":"gateway-A"},{"name":"b","origin":"gateway-A"}]}`,
+	},
 	{
 		"json2xml_1",
 		Spec("./test/json2xml/test1.yaml"),
@@ -89,3 +192,654 @@ func TestGenerateDataDefine(t *testing.T) {
 		assert.Equal(t, m1, m2)
 	}
 }
+
+func TestCompileConcurrent(t *testing.T) {
+	for _, testStruct := range mapperTest {
+		dataDefine, err := GenerateDataDefine(testStruct.Specification)
+		assert.Equal(t, err, nil)
+		if dataDefine.TargetType != "json" {
+			continue
+		}
+
+		plan, err := Compile(dataDefine)
+		assert.Equal(t, err, nil)
+
+		var m2 map[string]interface{}
+		assert.Equal(t, json.Unmarshal([]byte(testStruct.Output), &m2), nil)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				output, err := plan.Transform([]byte(testStruct.Input))
+				assert.Equal(t, err, nil)
+
+				var m1 map[string]interface{}
+				assert.Equal(t, json.Unmarshal(output, &m1), nil)
+				assert.Equal(t, m1, m2, testStruct.ID)
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+func TestCompileUnknownTarget(t *testing.T) {
+	dataDefine, err := GenerateDataDefine(Spec("./test/json2json/test1.yaml"))
+	assert.Equal(t, err, nil)
+	dataDefine.Mapper["data.voltage"] = &MapperRule{Target: "va.X"}
+
+	_, err = Compile(dataDefine)
+	assert.NotEqual(t, err, nil)
+
+	dataDefine.Mapper["data.voltage"] = &MapperRule{Target: "va.V", Transform: []string{"round(", "upper"}}
+	_, err = Compile(dataDefine)
+	assert.NotEqual(t, err, nil)
+
+	dataDefine.Mapper["data.voltage"] = &MapperRule{Target: "va.V", Transform: []string{"notDefined"}}
+	_, err = Compile(dataDefine)
+	assert.NotEqual(t, err, nil)
+}
+
+func TestValidate(t *testing.T) {
+	dataDefine, err := GenerateDataDefine(Spec("./test/validate/test1.yaml"))
+	assert.Equal(t, err, nil)
+
+	var problems []string
+	for _, problem := range dataDefine.Validate() {
+		problems = append(problems, problem.Error())
+	}
+	assert.Equal(t, []string{
+		`line 11: source.data.multiple: multiple must be true or false, got "ture"`,
+		`line 19: target.id.typeRef: unsupported simple typeRef "strnig"`,
+		`line 48: mapper.data.current: source path data.current: field data.current is not defined`,
+		`line 49: mapper.items.name: target path va.V is already mapped from data.voltage`,
+		`line 49: mapper.items.name: array depth mismatch: source items.name has 1 array levels but target va.V has 0`,
+		`line 50: mapper.data: target path va.X: field va.X is not defined`,
+	}, problems)
+
+	dataDefine, err = GenerateDataDefine(Spec("./test/json2json/test1.yaml"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(dataDefine.Validate()), 0)
+}
+
+func TestStrictMode(t *testing.T) {
+	dataDefine, err := GenerateDataDefine(Spec("./test/json2json/test3.yaml"))
+	assert.Equal(t, err, nil)
+	input := []byte(`{"id":"test3","description":"描述映射test3","voltage":"abc","ampere":10,"power":{"value":2200}}`)
+
+	output, err := dataDefine.To(input)
+	assert.Equal(t, err, nil)
+	assert.JSONEq(t, `{"id":"test3","description":"描述映射test3","V":0,"A":10,"P":0}`, string(output))
+
+	_, err = dataDefine.To(input, WithStrict())
+	var mappingErr *MappingError
+	assert.True(t, errors.As(err, &mappingErr))
+	assert.Equal(t, "power", mappingErr.SourcePath)
+	assert.Equal(t, "number", mappingErr.Expected)
+	assert.Equal(t, map[string]interface{}{"value": json.Number("2200")}, mappingErr.Value)
+
+	_, err = dataDefine.To(input, WithMultiError())
+	var multiErr MultiError
+	assert.True(t, errors.As(err, &multiErr))
+	assert.Equal(t, 2, len(multiErr))
+	assert.True(t, errors.As(multiErr[1], &mappingErr))
+	assert.Equal(t, "voltage", mappingErr.SourcePath)
+	assert.Equal(t, "abc", mappingErr.Value)
+}
+
+func TestLosslessNumber(t *testing.T) {
+	dataDefine, err := GenerateDataDefine(Spec("./test/json2json/test8.yaml"))
+	assert.Equal(t, err, nil)
+
+	output, err := dataDefine.To([]byte(`{"id":9007199254740993,"ts":1642757405418123,"amount":12345678901234567890.12345,"price":"7","rates":[0.25,"1e-2",3]}`))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, `{"amount":12345678901234567890.12345,"id":9007199254740993,"price":7.00,"rates":["0.3","0.0","3.0"],"time":"1642757405418123"}`, string(output))
+}
+
+//unregisterFunc 测试结束后移除注册的函数，使得测试可以重复运行
+func unregisterFunc(t *testing.T, names ...string) {
+	t.Cleanup(func() {
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		for _, name := range names {
+			delete(registry, name)
+		}
+	})
+}
+
+func TestRegisterFunc(t *testing.T) {
+	unregisterFunc(t, "deviceCode", "testChecksum")
+	assert.Equal(t, RegisterFunc("deviceCode", func(sn string, model int) string {
+		return fmt.Sprintf("M%03d-%s", model, sn)
+	}), nil)
+	assert.Equal(t, RegisterFunc("testChecksum", func(ctx *FuncContext, payload string) (string, error) {
+		if payload == "" {
+			return "", fmt.Errorf("empty payload for %s", ctx.TargetPath)
+		}
+		var sum byte
+		for i := 0; i < len(payload); i++ {
+			sum ^= payload[i]
+		}
+		return fmt.Sprintf("%02X", sum), nil
+	}), nil)
+
+	assert.NotEqual(t, RegisterFunc("trim", strings.TrimSpace), nil)
+	assert.NotEqual(t, RegisterFunc("deviceCode", strings.TrimSpace), nil)
+	assert.NotEqual(t, RegisterFunc("bad name", strings.TrimSpace), nil)
+	assert.NotEqual(t, RegisterFunc("notFunc", 42), nil)
+	assert.NotEqual(t, RegisterFunc("noResult", func(string) {}), nil)
+	assert.NotEqual(t, RegisterFunc("badArg", func([]byte) string { return "" }), nil)
+
+	dataDefine, err := GenerateDataDefine(Spec("./test/json2json/test12.yaml"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(dataDefine.Validate()), 0)
+	output, err := dataDefine.To([]byte(`{"sn":"A1","model":"7","payload":"abc"}`), WithStrict())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(output), `{"checksum":"60","deviceCode":"M007-A1","payload":"abc"}`)
+
+	_, err = dataDefine.To([]byte(`{"sn":"A1","model":7,"payload":""}`), WithStrict())
+	var mappingErr *MappingError
+	assert.True(t, errors.As(err, &mappingErr))
+	assert.Equal(t, mappingErr.TargetPath, "checksum")
+	assert.Equal(t, mappingErr.Err.Error(), "empty payload for checksum")
+}
+
+func TestRequired(t *testing.T) {
+	dataDefine, err := GenerateDataDefine(Spec("./test/json2json/test13.yaml"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(dataDefine.Validate()), 0)
+
+	_, err = dataDefine.To([]byte(`{"level":"warn"}`), WithMultiError())
+	var multiErr MultiError
+	assert.True(t, errors.As(err, &multiErr))
+	assert.True(t, errors.Is(err, ErrRequired))
+	// 不依赖Go 1.20的Unwrap() []error
+	var mappingErr *MappingError
+	assert.True(t, multiErr.Is(ErrRequired))
+	assert.True(t, multiErr.As(&mappingErr))
+	assert.Equal(t, mappingErr.SourcePath, "id")
+	assert.Equal(t, err.Error(), "2 errors occurred: mapping id: required field is missing; mapping -> id: required field is missing")
+
+	// 宽松模式下只记录日志，目标字段保留零值
+	output, err := dataDefine.To([]byte(`{"level":"warn"}`))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(output), `{"id":"","level":"warn","ratio":1.00,"retry":3,"status":"unknown","tags":["default"]}`)
+
+	delete(dataDefine.Mapper, "id")
+	problems := dataDefine.Validate()
+	assert.Equal(t, len(problems), 1)
+	assert.Equal(t, problems[0].Error(), "line 25: target.id: required target field id is never mapped")
+
+	// 整体写入的对象包含其中的必填字段
+	dataDefine, err = GenerateDataDefine([]byte(`
+sourceType: json
+targetType: json
+source:
+  items:
+    type: complex
+    typeRef: item
+target:
+  out:
+    type: complex
+    typeRef: item
+complex:
+  item:
+    name:
+      type: simple
+      typeRef: string
+      required: true
+mapper:
+  items: out
+`))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(dataDefine.Validate()), 0)
+	output, err = dataDefine.To([]byte(`{"items":{"name":"a"}}`), WithStrict())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(output), `{"out":{"name":"a"}}`)
+
+	// 源对象中缺少的必填字段
+	_, err = dataDefine.To([]byte(`{"items":{}}`), WithMultiError())
+	assert.True(t, errors.Is(err, ErrRequired))
+	assert.Equal(t, err.Error(), "2 errors occurred: mapping items.name: required field is missing; mapping -> out.name: required field is missing")
+	output, err = dataDefine.To([]byte(`{"items":{"name":null}}`))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(output), `{"out":{"name":""}}`)
+
+	// 源类型中不存在同名字段时静态检查报告
+	dataDefine.Complex["source"] = ComplexDefine{"id": &DataSpec{Type: "simple", TypeRef: "string"}}
+	dataDefine.Source["items"].TypeRef = "source"
+	problems = dataDefine.Validate()
+	assert.Equal(t, len(problems), 1)
+	assert.Equal(t, problems[0].Error(), "line 9: target.out.name: required target field out.name is never mapped")
+}
+
+func TestLookup(t *testing.T) {
+	dataDefine, err := GenerateDataDefine(Spec("./test/json2json/test15.yaml"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(dataDefine.Validate()), 0)
+	_, err = Compile(dataDefine)
+	assert.Equal(t, err.Error(), "lookup states: table ./test/json2json/lookup_states.yaml is not loaded, call LoadLookups first")
+
+	assert.Equal(t, dataDefine.LoadLookups(NewSpecFileReader()), nil)
+	output, err := dataDefine.To([]byte(`{"code":"SUCCESS","state":"Run","mode":"auto"}`), WithStrict())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(output), `{"code":0,"label":"ok","mode":"unknown","state":"running"}`)
+
+	output, err = dataDefine.To([]byte(`{"code":"TIMEOUT","state":"Stop","mode":"Run"}`), WithStrict())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(output), `{"code":-1,"label":"error","mode":"running","state":"stopped"}`)
+
+	_, err = dataDefine.To([]byte(`{"code":"SUCCESS","state":"Idle"}`), WithStrict())
+	assert.True(t, errors.Is(err, ErrKeyNotFound))
+
+	dataDefine.Mapper["mode"].Transform = []string{"lookup('modes')"}
+	problems := dataDefine.Validate()
+	assert.Equal(t, len(problems), 1)
+	assert.Equal(t, problems[0].Error(), "line 48: mapper.mode: lookup table modes is not defined")
+
+	// 空的转换表文件与内联的值合并，不是对象的文件返回错误
+	dataDefine.Lookups["states"].Values = map[string]interface{}{"Run": "running"}
+	assert.Equal(t, dataDefine.LoadLookups(specReader{"./test/json2json/lookup_states.yaml": ""}), nil)
+	assert.Equal(t, dataDefine.Lookups["states"].Values, map[string]interface{}{"Run": "running"})
+	assert.Equal(t, dataDefine.LoadLookups(specReader{"./test/json2json/lookup_states.yaml": "null"}), nil)
+	err = dataDefine.LoadLookups(specReader{"./test/json2json/lookup_states.yaml": "- Run\n- Stop"})
+	assert.Equal(t, err.Error(), "lookup states: ./test/json2json/lookup_states.yaml is not a mapping")
+}
+
+//specReader 从内存中读取规格信息
+type specReader map[string]string
+
+func (r specReader) Get(name string) ([]byte, error) {
+	data, ok := r[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return []byte(data), nil
+}
+
+func TestRecursiveType(t *testing.T) {
+	dataDefine, err := GenerateDataDefine(Spec("./test/json2json/test20.yaml"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(dataDefine.Validate()), 0)
+
+	input := []byte(`{"title":"bom","menu":[{"name":"a","qty":1,"children":[{"name":"a1","qty":2,"children":[{"name":"a11","qty":3}]}]},{"name":"b","qty":4}]}`)
+	output, err := dataDefine.To(input, WithStrict())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(output), `{"items":[{"children":[{"children":[{"children":[],"name":"a11","qty":3}],"name":"a1","qty":2}],"name":"a","qty":1},{"children":[],"name":"b","qty":4}],"title":"bom"}`)
+
+	// 超过最大深度的层级被丢弃
+	_, err = dataDefine.To(input, WithStrict(), WithMaxDepth(2))
+	assert.True(t, errors.Is(err, ErrMaxDepth))
+	assert.Equal(t, err.Error(), "mapping menu[0].children[0].children: max depth exceeded")
+	output, err = dataDefine.To(input, WithMaxDepth(2))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(output), `{"items":[{"children":[{"children":[],"name":"a1","qty":2}],"name":"a","qty":1},{"children":[],"name":"b","qty":4}],"title":"bom"}`)
+}
+
+func TestOneOf(t *testing.T) {
+	dataDefine, err := GenerateDataDefine(Spec("./test/json2json/test22.yaml"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(dataDefine.Validate()), 0)
+
+	output, err := dataDefine.To([]byte(`{"messages":[],"payload":{"x":1,"y":2}}`), WithStrict())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(output), `{"alarms":[],"detail":{"x":1,"y":2},"detailKind":"point","events":[]}`)
+
+	_, err = dataDefine.To([]byte(`{"messages":[{"type":"noise"},{"value":1}],"payload":{"x":1,"z":2}}`), WithMultiError())
+	assert.True(t, errors.Is(err, ErrNoVariant))
+	assert.Equal(t, err.Error(), "3 errors occurred: mapping messages[0]: no matching oneOf variant: discriminator type is noise; "+
+		"mapping messages[1]: no matching oneOf variant: discriminator type is missing; mapping payload: no matching oneOf variant")
+
+	dataDefine.Source["messages"].Variants["temp"] = "humidityMsg"
+	problems := dataDefine.Validate()
+	assert.Equal(t, len(problems), 1)
+	assert.Equal(t, problems[0].Error(), "line 8: source.messages.variants: variant temp refers to \"humidityMsg\" which is not listed in oneOf")
+}
+
+func TestAny(t *testing.T) {
+	dataDefine, err := GenerateDataDefine(Spec("./test/json2json/test23.yaml"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(dataDefine.Validate()), 0)
+
+	// 未声明的结构原样保留，null也会写入目标
+	output, err := dataDefine.To([]byte(`{"headers":null,"extra":[1,"a",{"b":2}],"code":"OK","properties":[]}`), WithStrict())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(output), `{"code":"OK","device":{"id":"","port":0},"extra":[1,"a",{"b":2}],"headers":null,"missing":null,"props":[],"token":""}`)
+
+	dataDefine.Mapper["code"].Target = "extra.code"
+	problems := dataDefine.Validate()
+	assert.Equal(t, len(problems), 1)
+	assert.Equal(t, problems[0].Error(), "line 59: mapper.code: target path extra.code: can't write into the fields of an any field")
+}
+
+func TestPassthrough(t *testing.T) {
+	dataDefine, err := GenerateDataDefine(Spec("./test/json2json/test24.yaml"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(dataDefine.Validate()), 0)
+
+	// 只复制include中的路径，mapper写入的目标路径不会被覆盖
+	dataDefine.Passthrough = &PassthroughRule{Enabled: true, Include: []string{"data", "va"}}
+	output, err := dataDefine.To([]byte(`{"id":"x","data":{"voltage":220,"current":10},"va":{"V":1,"A":2},"meta":{"trace":"t"}}`), WithStrict())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(output), `{"code":0,"data":{"current":10},"deviceId":"x","va":{"A":2,"V":220}}`)
+
+	dataDefine.Passthrough.Enabled = false
+	output, err = dataDefine.To([]byte(`{"id":"x","data":{"voltage":220,"current":10}}`), WithStrict())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(output), `{"code":0,"deviceId":"x","va":{"V":220}}`)
+}
+
+func TestWildcard(t *testing.T) {
+	dataDefine, err := GenerateDataDefine(Spec("./test/json2json/test25.yaml"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(dataDefine.Validate()), 0)
+
+	// 没有rename时只有同名的字段可以映射
+	dataDefine.Mapper["data.*"].Rename = nil
+	var problems []string
+	for _, problem := range dataDefine.Validate() {
+		problems = append(problems, problem.Error())
+	}
+	assert.Equal(t, problems, []string{
+		"line 70: mapper.data.*: wildcard field dev_serial_no has no matching field in target va.*",
+		"line 70: mapper.data.*: wildcard field power_factor has no matching field in target va.*",
+	})
+
+	dataDefine.Mapper["id.*"] = &MapperRule{Target: "va.*"}
+	_, err = Compile(dataDefine)
+	assert.Equal(t, err.Error(), "mapper id.*: wildcard source id is not a complex field")
+}
+
+func TestConstants(t *testing.T) {
+	dataDefine, err := GenerateDataDefine(Spec("./test/json2json/test26.yaml"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(dataDefine.Validate()), 0)
+
+	// 常量与映射的值使用相同的类型检查
+	dataDefine.Constants["schemaVersion"] = "v2"
+	dataDefine.Constants["id"] = "fixed"
+	var problems []string
+	for _, problem := range dataDefine.Validate() {
+		problems = append(problems, problem.Error())
+	}
+	assert.Equal(t, problems, []string{
+		"line 66: constants.id: target path id is already mapped from id",
+		"line 67: constants.schemaVersion: mapping -> schemaVersion: expected integer, got string(v2): \"v2\" is not an integer",
+	})
+	_, err = dataDefine.To([]byte(`{"id":"dev1"}`), WithStrict())
+	assert.Equal(t, err.Error(), "mapping -> schemaVersion: expected integer, got string(v2): \"v2\" is not an integer")
+}
+
+func TestParams(t *testing.T) {
+	dataDefine, err := GenerateDataDefine(Spec("./test/json2json/test27.yaml"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(dataDefine.Validate()), 0)
+
+	input := []byte(`{"id":"dev1","properties":[{"name":"a"},{"name":"b"}]}`)
+	output, err := dataDefine.To(input, WithStrict(), WithParams(map[string]interface{}{
+		"tenant": "t1",
+		"serial": "SN01",
+		"port":   8080,
+	}))
+	assert.Equal(t, err, nil)
+	assert.JSONEq(t, string(output), `{"id":"dev1","tenant":"t1","label":"cn/SN01","port":8080,
+		"datas":[{"name":"a","tenant":"SN01"},{"name":"b","tenant":"SN01"}]}`)
+
+	// 参数可以是任意的Go数值类型
+	port := 8080
+	for _, value := range []interface{}{int32(8080), uint(8080), uint16(8080), float32(8080), &port} {
+		output, err = dataDefine.To(input, WithStrict(), WithParams(map[string]interface{}{"tenant": "t1", "serial": "SN01", "port": value}))
+		assert.Equal(t, err, nil)
+		assert.JSONEq(t, string(output), `{"id":"dev1","tenant":"t1","label":"cn/SN01","port":8080,
+			"datas":[{"name":"a","tenant":"SN01"},{"name":"b","tenant":"SN01"}]}`)
+	}
+
+	// 必填的参数缺失
+	_, err = dataDefine.To(input, WithStrict(), WithParams(map[string]interface{}{"serial": "SN01"}))
+	assert.Equal(t, errors.Is(err, ErrRequired), true)
+	assert.Equal(t, err.Error(), "mapping $params.tenant: required field is missing")
+
+	// 引用没有声明的参数
+	dataDefine.SetMapper("$params.unknown", "id")
+	delete(dataDefine.Mapper, "id")
+	var problems []string
+	for _, problem := range dataDefine.Validate() {
+		problems = append(problems, problem.Error())
+	}
+	assert.Equal(t, problems, []string{"line 63: mapper.$params.unknown: source path $params.unknown: field $params.unknown is not defined"})
+
+	// 没有声明参数时原样使用传入的参数
+	dataDefine, err = GenerateDataDefine([]byte(`
+sourceType: json
+targetType: json
+target:
+  tenant:
+    type: simple
+    typeRef: string
+mapper:
+  $params.tenant: tenant
+`))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(dataDefine.Validate()), 0)
+	output, err = dataDefine.To([]byte(`{}`), WithParams(map[string]interface{}{"tenant": "t2"}))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(output), `{"tenant":"t2"}`)
+}
+
+func TestGenerators(t *testing.T) {
+	dataDefine, err := GenerateDataDefine(Spec("./test/json2json/test28.yaml"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(dataDefine.Validate()), 0)
+
+	clock := func() time.Time { return time.Date(2024, 5, 1, 20, 30, 0, 0, time.UTC) }
+	random := func() io.Reader { return bytes.NewReader(bytes.Repeat([]byte{0xab}, 64)) }
+	input := []byte(`{"id":"dev1"}`)
+	output, err := dataDefine.To(input, WithClock(clock), WithRandom(random()))
+	assert.Equal(t, err, nil)
+	assert.JSONEq(t, string(output), `{"id":"dev1","messageId":"abababab-abab-4bab-abab-abababababab",
+		"traceId":"018f35d9-8940-7bab-abab-abababababab","seqNo":2,"processedAt":1714595400000,"day":"2024-05-02",
+		"out":[{"name":"","id":"abababab-abab-4bab-abab-abababababab","n":1}]}`)
+
+	// 序列属于规格，每次转换递增
+	plan, err := Compile(dataDefine)
+	assert.Equal(t, err, nil)
+	output, err = plan.Transform(input, WithClock(clock), WithRandom(random()))
+	assert.Equal(t, err, nil)
+	assert.Contains(t, string(output), `"seqNo":4`)
+
+	// 写入数组时每一个元素分别生成
+	output, err = plan.Transform([]byte(`{"id":"dev1","items":[{"name":"a"},{"name":"b"},{"name":"c"}]}`), WithStrict())
+	assert.Equal(t, err, nil)
+	var res struct {
+		SeqNo int64
+		Out   []struct {
+			ID string
+			N  int64
+		}
+	}
+	assert.Equal(t, json.Unmarshal(output, &res), nil)
+	assert.Equal(t, len(res.Out), 3)
+	ids := make(map[string]bool)
+	for i, elem := range res.Out {
+		ids[elem.ID] = true
+		assert.Equal(t, elem.N, int64(5+i))
+	}
+	assert.Equal(t, len(ids), 3)
+	assert.Equal(t, res.SeqNo, int64(8))
+
+	// 结果经过数组的表达式中不能使用生成函数
+	dataDefine.Computed["out.id"] = "concat(items.name, uuid())"
+	problems := dataDefine.Validate()
+	assert.Equal(t, len(problems), 1)
+	assert.Equal(t, problems[0].Error(), "line 70: computed.out.id: function uuid cannot be combined with array paths, it would generate one value for all elements")
+
+	// 随机数来源出错时报告转换错误
+	_, err = plan.Transform(input, WithStrict(), WithClock(clock), WithRandom(bytes.NewReader(nil)))
+	assert.Equal(t, err.Error(), "mapping uuid() -> messageId: uuid: EOF")
+}
+
+func TestDateTimeInvalid(t *testing.T) {
+	dataDefine, err := GenerateDataDefine(Spec("./test/json2json/test9.yaml"))
+	assert.Equal(t, err, nil)
+
+	_, err = dataDefine.To([]byte(`{"time":"99999999999999999999"}`), WithStrict())
+	assert.Equal(t, err.Error(), `mapping time: expected datetime, got string(99999999999999999999): epoch_ms timestamp 99999999999999999999 is out of range`)
+
+	// 宽松模式下解析失败的字段保持为null
+	output, err := dataDefine.To([]byte(`{"time":"99999999999999999999","created":"2022-01-21","updated":["2022-01-21T09:30:05.418Z"]}`))
+	assert.Equal(t, err, nil)
+	assert.JSONEq(t, string(output), `{"time":null,"created":null,"updated":[1642757405418],"deleted":null}`)
+}
+
+func TestFuncLimits(t *testing.T) {
+	dataDefine, err := GenerateDataDefine(Spec("./test/json2json/test10.yaml"))
+	assert.Equal(t, err, nil)
+	dataDefine.Mapper["properties.val"].Transform = []string{"round(100000000)"}
+	dataDefine.Mapper["properties.unit"].Transform = []string{"padLeft(100000000, '*')"}
+	input := []byte(`{"code":"SUCCESS","messageId":"f09856be6ae947a79ca21d24a33e7239","properties":[{"val":"0.0712","name":"cpu","unit":"%"}]}`)
+
+	_, err = dataDefine.To(input, WithMultiError())
+	var multiErr MultiError
+	assert.True(t, errors.As(err, &multiErr))
+	assert.Equal(t, len(multiErr), 2)
+	var mappingErr *MappingError
+	assert.True(t, errors.As(multiErr[0], &mappingErr))
+	assert.Equal(t, err.Error(), "2 errors occurred: "+
+		"mapping properties.unit -> datas.unit: got string(%): transform padLeft(100000000, '*'): pad width must not exceed 4096, got 100000000; "+
+		"mapping properties.val -> datas.val: got string(0.0712): transform round(100000000): round digits must be between 0 and 64, got 100000000")
+}
+
+func TestCardinality(t *testing.T) {
+	dataDefine, err := GenerateDataDefine(Spec("./test/json2json/test16.yaml"))
+	assert.Equal(t, err, nil)
+	input := []byte(`{"messageId":"f09856be","properties":[{"val":"7.00","name":"CPU使用率"},{"val":"8.00","name":"CPU使用率"}]}`)
+
+	// 筛选后得到多个元素时需要通过下标选择
+	_, err = dataDefine.To(input, WithStrict())
+	assert.True(t, errors.Is(err, ErrCardinality))
+	assert.Equal(t, err.Error(), `mapping properties[name=="CPU使用率"].val -> cpu: expected number, got []interface {}([7.00 8.00]): `+ErrCardinality.Error())
+	output, err := dataDefine.To(input)
+	assert.Equal(t, err, nil)
+	assert.JSONEq(t, string(output), `{"id":"f09856be/0","cpu":7,"memory":0,"disk":null,"alarms":[]}`)
+
+	delete(dataDefine.Mapper, `properties[name=="CPU使用率"].val`)
+	dataDefine.SetMapper(`properties[name=="CPU使用率"][1].val`, "cpu")
+	assert.Equal(t, len(dataDefine.Validate()), 0)
+	output, err = dataDefine.To(input, WithStrict())
+	assert.Equal(t, err, nil)
+	assert.JSONEq(t, string(output), `{"id":"f09856be/0","cpu":8,"memory":0,"disk":null,"alarms":[]}`)
+
+	// 源数据中单个值的字段传入数组
+	_, err = dataDefine.To([]byte(`{"messageId":["a","b"]}`), WithMultiError())
+	assert.True(t, errors.Is(err, ErrCardinality))
+	assert.Equal(t, err.Error(), "1 errors occurred: mapping messageId: expected string, got []interface {}([a b]): "+ErrCardinality.Error())
+	output, err = dataDefine.To([]byte(`{"messageId":["a","b"]}`))
+	assert.Equal(t, err, nil)
+	assert.JSONEq(t, string(output), `{"id":"a/0","cpu":0,"memory":0,"disk":null,"alarms":[{"name":"","quality":0}]}`)
+
+	// 写入单个对象的字段
+	dataDefine, err = GenerateDataDefine([]byte(`
+sourceType: json
+targetType: json
+source:
+  items:
+    type: complex
+    typeRef: item
+    multiple: true
+target:
+  out:
+    type: complex
+    typeRef: item
+complex:
+  item:
+    name:
+      type: simple
+      typeRef: string
+mapper:
+  items: out
+`))
+	assert.Equal(t, err, nil)
+	output, err = dataDefine.To([]byte(`{"items":[{"name":"a"}]}`), WithStrict())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(output), `{"out":{"name":"a"}}`)
+	_, err = dataDefine.To([]byte(`{"items":[{"name":"a"},{"name":"b"}]}`), WithStrict())
+	assert.True(t, errors.Is(err, ErrCardinality))
+	dataDefine.SetMapper("items[0]", "out")
+	delete(dataDefine.Mapper, "items")
+	output, err = dataDefine.To([]byte(`{"items":[{"name":"a"},{"name":"b"}]}`), WithStrict())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(output), `{"out":{"name":"a"}}`)
+}
+
+func TestTruthy(t *testing.T) {
+	dataDefine, err := GenerateDataDefine([]byte(`
+sourceType: json
+targetType: json
+source:
+  flag:
+    type: any
+target:
+  result:
+    type: simple
+    typeRef: string
+computed:
+  result: "flag ? 'yes' : 'no'"
+`))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(dataDefine.Validate()), 0)
+	// 字符串中只有""与"false"为假
+	for input, expected := range map[string]string{
+		`{"flag":""}`:      "no",
+		`{"flag":"false"}`: "no",
+		`{"flag":"False"}`: "yes",
+		`{"flag":"0"}`:     "yes",
+		`{"flag":"no"}`:    "yes",
+		`{"flag":0}`:       "no",
+		`{"flag":false}`:   "no",
+		`{"flag":null}`:    "no",
+		`{"flag":1.5}`:     "yes",
+	} {
+		output, err := dataDefine.To([]byte(input), WithStrict())
+		assert.Equal(t, err, nil)
+		assert.Equal(t, string(output), `{"result":"`+expected+`"}`, input)
+	}
+}
+
+func TestCompileCopiesSpec(t *testing.T) {
+	dataDefine, err := GenerateDataDefine(Spec("./test/json2json/test8.yaml"))
+	assert.Equal(t, err, nil)
+	plan, err := Compile(dataDefine)
+	assert.Equal(t, err, nil)
+
+	// 编译后修改DataDefine中的规格不影响已经编译的Plan
+	*dataDefine.Target["price"].Scale = 0
+	*dataDefine.Source["rates"].Scale = 3
+	output, err := plan.Transform([]byte(`{"price":"7","rates":[0.25]}`), WithStrict())
+	assert.Equal(t, err, nil)
+	assert.JSONEq(t, string(output), `{"amount":0,"id":0,"price":7.00,"rates":["0.3"],"time":""}`)
+
+	dataDefine, err = GenerateDataDefine(Spec("./test/json2json/test22.yaml"))
+	assert.Equal(t, err, nil)
+	plan, err = Compile(dataDefine)
+	assert.Equal(t, err, nil)
+	dataDefine.Source["messages"].OneOf[0] = "point"
+	dataDefine.Source["messages"].Variants["temp"] = "alarmMsg"
+	dataDefine.Source["payload"].OneOf[1] = "tempMsg"
+	output, err = plan.Transform([]byte(`{"messages":[{"type":"temp","value":1}],"payload":{"x":1,"y":2}}`), WithStrict())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(output), `{"alarms":[],"detail":{"x":1,"y":2},"detailKind":"point","events":[{"code":"","kind":"tempMsg","value":1}]}`)
+	_, err = plan.Transform([]byte(`{"messages":[],"payload":"abc"}`), WithStrict())
+	assert.Equal(t, err.Error(), "mapping payload: expected oneOf(point|text), got string(abc)")
+}
+
+func TestClone(t *testing.T) {
+	source := map[string]interface{}{"id": "dev1", "value": 1.5, "tags": []interface{}{"a", 2}}
+	res := Clone(source).(map[string]interface{})
+	assert.Equal(t, res, map[string]interface{}{"id": "dev1", "value": 1.5, "tags": []interface{}{"a", float64(2)}})
+	res["id"] = "dev2"
+	assert.Equal(t, source["id"], "dev1")
+
+	ptr := Clone(&source).(*map[string]interface{})
+	assert.Equal(t, (*ptr)["value"], 1.5)
+}
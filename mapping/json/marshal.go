@@ -1,11 +1,26 @@
 package json
 
-import "encoding/json"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
 
 func Marshal(v interface{}) ([]byte, error) {
 	return json.Marshal(v)
 }
 
+//Unmarshal 数值会被解析为json.Number而不是float64，避免大整数与小数在解析时丢失精度
 func Unmarshal(data []byte, v interface{}) error {
-	return json.Unmarshal(data, v)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	// 与json.Unmarshal保持一致，不允许在数据之后存在其他内容
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid character after top-level value")
+	}
+	return nil
 }
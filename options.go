@@ -0,0 +1,87 @@
+package datamapper
+
+import (
+	"crypto/rand"
+	"io"
+	"time"
+)
+
+//Option 转换时的可选参数
+type Option func(*options)
+
+//defaultMaxDepth 没有通过WithMaxDepth设置时复杂类型允许的最大嵌套层级
+const defaultMaxDepth = 32
+
+type options struct {
+	mode errorMode
+	//maxDepth 复杂类型允许的最大嵌套层级，用于限制自引用类型的深度
+	maxDepth int
+	//params 本次转换的运行时参数
+	params map[string]interface{}
+	//now与random为now、uuid等生成函数使用的时钟与随机数来源
+	now    func() time.Time
+	random io.Reader
+}
+
+//WithStrict 转换过程中出现无法转换的值时返回*MappingError，而不是记录日志后使用零值
+func WithStrict() Option {
+	return func(o *options) {
+		o.mode = strict
+	}
+}
+
+//WithMultiError 与WithStrict类似，但会完成整个转换并通过MultiError返回所有的错误
+func WithMultiError() Option {
+	return func(o *options) {
+		o.mode = collect
+	}
+}
+
+//WithMaxDepth 设置源数据与目标数据中对象允许的最大嵌套层级，根对象为第0层，默认为32
+//自引用的复杂类型按照数据的实际层级展开，超出最大深度的部分会被丢弃并报告ErrMaxDepth，n小于1时使用默认值
+func WithMaxDepth(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.maxDepth = n
+		}
+	}
+}
+
+//WithParams 传入本次转换的运行时参数，例如租户ID或设备序列号，mapper与表达式中通过$params.tenant引用
+//参数按照DataDefine中params的声明转换类型，多次调用时合并所有的参数
+func WithParams(params map[string]interface{}) Option {
+	return func(o *options) {
+		if o.params == nil {
+			o.params = make(map[string]interface{}, len(params))
+		}
+		for key, value := range params {
+			o.params[key] = value
+		}
+	}
+}
+
+//WithClock 替换now与uuidv7使用的时钟，默认为time.Now，用于在测试中得到确定的结果
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		if now != nil {
+			o.now = now
+		}
+	}
+}
+
+//WithRandom 替换uuid与uuidv7使用的随机数来源，默认为crypto/rand.Reader
+func WithRandom(random io.Reader) Option {
+	return func(o *options) {
+		if random != nil {
+			o.random = random
+		}
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{maxDepth: defaultMaxDepth, now: time.Now, random: rand.Reader}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
@@ -0,0 +1,189 @@
+package datamapper
+
+import (
+	"fmt"
+	"strings"
+)
+
+//PassthroughRule 将mapper没有使用的输入字段复制到输出中相同的路径
+//可以直接写成 passthrough: true，也可以写成包含include与exclude的对象
+type PassthroughRule struct {
+	//Enabled 是否复制没有使用的字段，写成对象时为true
+	Enabled bool `yaml:"-"`
+	//Include 只复制这些路径以及其中的字段，为空时复制所有没有使用的字段
+	Include []string `yaml:"include"`
+	//Exclude 不复制这些路径以及其中的字段，优先于Include
+	Exclude []string `yaml:"exclude"`
+}
+
+//UnmarshalYAML 兼容只写true或false的写法
+func (p *PassthroughRule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var enabled bool
+	if err := unmarshal(&enabled); err == nil {
+		p.Enabled = enabled
+		return nil
+	}
+	type plain PassthroughRule
+	if err := unmarshal((*plain)(p)); err != nil {
+		return err
+	}
+	p.Enabled = true
+	return nil
+}
+
+//passthrough 编译后的复制规则，路径均已按照.切分
+type passthrough struct {
+	//consumed mapper与计算字段使用的源路径，其中的*可以匹配任意key
+	consumed [][]string
+	include  [][]string
+	exclude  [][]string
+}
+
+//compilePassthrough 根据已经编译的规则生成复制规则，没有启用时返回nil
+func compilePassthrough(pr *PassthroughRule, rules []*rule) (*passthrough, error) {
+	if pr == nil || !pr.Enabled {
+		return nil, nil
+	}
+	p := &passthrough{}
+	for _, r := range rules {
+		if r.expr == nil {
+			p.consumed = append(p.consumed, segmentKeys(r.sourcePaths))
+			continue
+		}
+		for _, ref := range pathRefs(r.expr) {
+			p.consumed = append(p.consumed, segmentKeys(ref.segs))
+		}
+	}
+	var err error
+	if p.include, err = splitPaths(pr.Include); err != nil {
+		return nil, fmt.Errorf("passthrough include: %v", err)
+	}
+	if p.exclude, err = splitPaths(pr.Exclude); err != nil {
+		return nil, fmt.Errorf("passthrough exclude: %v", err)
+	}
+	return p, nil
+}
+
+//splitPaths 将不带下标与筛选条件的路径按照.切分
+func splitPaths(paths []string) ([][]string, error) {
+	res := make([][]string, 0, len(paths))
+	for _, path := range paths {
+		segs, err := splitTargetPath(path)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, segmentKeys(segs))
+	}
+	return res, nil
+}
+
+//passthrough 将输入中没有被使用的字段复制到targetMap中相同的路径，已经被规则写入的目标路径不会被覆盖
+//目标中声明的字段按照规格转换，没有声明的字段原样复制，只有对象会被逐层展开，数组作为整体复制
+func (m *mapping) passthrough(p *passthrough, inputMap map[string]interface{}, ct *complexType, targetMap map[string]*interface{}) {
+	written := make([][]string, 0, len(m.written))
+	for path := range m.written {
+		written = append(written, strings.Split(path, "."))
+	}
+	m.copyUnused(p, written, inputMap, nil, ct, targetMap)
+}
+
+//copyUnused 复制inputMap中没有被使用的字段，path为inputMap在输入中的路径，ct为nil时表示目标中没有声明对应的结构
+func (m *mapping) copyUnused(p *passthrough, written [][]string, inputMap map[string]interface{}, path []string, ct *complexType, targetMap map[string]*interface{}) {
+	for _, key := range sortedMapKeys(inputMap) {
+		value := inputMap[key]
+		keys := append(append([]string{}, path...), key)
+		if matchAny(p.exclude, keys, false) || matchAny(p.consumed, keys, true) || matchAny(written, keys, true) {
+			continue
+		}
+		var f *field
+		if ct != nil {
+			f = ct.fields[key]
+		}
+		// 部分字段已经被使用、写入、包含或者排除，以及目标中声明为对象时逐层展开
+		obj, isObj := value.(map[string]interface{})
+		descend := matchBelow(p.consumed, keys) || matchBelow(written, keys) || matchBelow(p.include, keys) || matchBelow(p.exclude, keys) ||
+			(f != nil && f.complex != nil && !f.spec.IsArray() && !f.spec.IsMap())
+		if descend {
+			if !isObj || (f != nil && f.complex == nil) {
+				continue
+			}
+			m.copyObjectUnused(p, written, obj, keys, f, targetMap)
+			continue
+		}
+		if len(p.include) > 0 && !matchAny(p.include, keys, false) {
+			continue
+		}
+		if f == nil {
+			targetMap[key] = &value
+			continue
+		}
+		segs := make([]segment, 0, len(keys))
+		for _, k := range keys {
+			segs = append(segs, segment{key: k})
+		}
+		joined := strings.Join(keys, ".")
+		m.setTargetData(targetMap, ct, segs[len(segs)-1:], value, &rule{source: joined, target: joined, targetPaths: segs})
+	}
+}
+
+//copyObjectUnused 展开对象并复制其中没有被使用的字段，目标中没有对应的对象时只在复制了字段后才会生成
+func (m *mapping) copyObjectUnused(p *passthrough, written [][]string, obj map[string]interface{}, keys []string, f *field, targetMap map[string]*interface{}) {
+	key := keys[len(keys)-1]
+	if f != nil {
+		slot, ok := targetMap[key]
+		if !ok {
+			return
+		}
+		if *slot == nil {
+			*slot = generateMap(f.complex)
+		}
+		if child, ok := (*slot).(map[string]*interface{}); ok {
+			m.copyUnused(p, written, obj, keys, f.complex, child)
+		}
+		return
+	}
+	child := make(map[string]*interface{})
+	if slot, ok := targetMap[key]; ok {
+		if existing, ok := (*slot).(map[string]*interface{}); ok {
+			child = existing
+		}
+	}
+	m.copyUnused(p, written, obj, keys, nil, child)
+	if len(child) > 0 {
+		var v interface{} = child
+		targetMap[key] = &v
+	}
+}
+
+//matchAny 判断keys是否与paths中的某一个路径相同，exact为false时keys位于路径之下也算匹配，路径中的*可以匹配任意key
+func matchAny(paths [][]string, keys []string, exact bool) bool {
+	for _, path := range paths {
+		if len(path) > len(keys) || (exact && len(path) != len(keys)) {
+			continue
+		}
+		if matchPrefix(path, keys) {
+			return true
+		}
+	}
+	return false
+}
+
+//matchBelow 判断paths中是否有位于keys之下的路径
+func matchBelow(paths [][]string, keys []string) bool {
+	for _, path := range paths {
+		if len(path) > len(keys) && matchPrefix(keys, path) {
+			return true
+		}
+	}
+	return false
+}
+
+//matchPrefix 判断prefix是否为keys的前缀，prefix与keys中的*可以匹配任意key
+func matchPrefix(prefix, keys []string) bool {
+	for i, key := range prefix {
+		if key != keys[i] && key != wildcardKey && keys[i] != wildcardKey {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,233 @@
+package datamapper
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//segment 路径中的一段，filter为对象数组元素的筛选条件，例如 properties[name=="CPU使用率"]
+//筛选条件中的路径优先相对于数组元素查找，找不到时再从源数据的根节点查找
+//index为数组的下标或切片，例如 properties[0]、properties[-1]、properties[1:3]，同时存在时先筛选再取下标
+type segment struct {
+	key    string
+	filter expr
+	index  *index
+}
+
+//index 数组的下标或切片，负数表示从数组末尾开始计算
+type index struct {
+	start int
+	end   int
+	//slice 为true时表示[start:end]，hasEnd为false时表示一直到数组末尾
+	slice  bool
+	hasEnd bool
+}
+
+var (
+	indexPattern = regexp.MustCompile(`^\s*(-?[0-9]+)\s*$`)
+	slicePattern = regexp.MustCompile(`^\s*(-?[0-9]+)?\s*:\s*(-?[0-9]+)?\s*$`)
+)
+
+//parseIndex 解析方括号中的下标或切片，不是下标或切片时返回false
+func parseIndex(text string) (*index, bool) {
+	if m := indexPattern.FindStringSubmatch(text); m != nil {
+		i, err := strconv.Atoi(m[1])
+		return &index{start: i}, err == nil
+	}
+	m := slicePattern.FindStringSubmatch(text)
+	if m == nil {
+		return nil, false
+	}
+	idx := &index{slice: true}
+	var err error
+	if m[1] != "" {
+		if idx.start, err = strconv.Atoi(m[1]); err != nil {
+			return nil, false
+		}
+	}
+	if m[2] != "" {
+		if idx.end, err = strconv.Atoi(m[2]); err != nil {
+			return nil, false
+		}
+		idx.hasEnd = true
+	}
+	return idx, true
+}
+
+//bounds 返回长度为n的数组中下标或切片对应的范围[lo, hi)，下标越界时返回false
+func (idx *index) bounds(n int) (int, int, bool) {
+	if !idx.slice {
+		i := idx.start
+		if i < 0 {
+			i += n
+		}
+		return i, i + 1, i >= 0 && i < n
+	}
+	lo, hi := idx.start, n
+	if idx.hasEnd {
+		hi = idx.end
+	}
+	if lo < 0 {
+		lo += n
+	}
+	if hi < 0 {
+		hi += n
+	}
+	lo, hi = clamp(lo, n), clamp(hi, n)
+	if lo > hi {
+		lo = hi
+	}
+	return lo, hi, true
+}
+
+//targetBounds 返回写入长度为n的目标数组时下标或切片对应的范围[lo, hi)，hi可以超出数组长度
+//count为写入切片的元素个数，切片没有声明结束位置时写入count个元素，count为-1时写入到数组末尾
+//负数下标超出数组范围时返回false
+func (idx *index) targetBounds(n, count int) (int, int, bool) {
+	if !idx.slice {
+		i := idx.start
+		if i < 0 {
+			i += n
+		}
+		return i, i + 1, i >= 0
+	}
+	lo := idx.start
+	if lo < 0 {
+		lo = clamp(lo+n, n)
+	}
+	hi := n
+	switch {
+	case idx.hasEnd && idx.end < 0:
+		hi = idx.end + n
+	case idx.hasEnd:
+		hi = idx.end
+	case count >= 0:
+		hi = lo + count
+	}
+	if hi < lo {
+		hi = lo
+	}
+	return lo, hi, true
+}
+
+//pick 从数组中取出下标对应的元素或切片对应的子数组，value不是数组或下标越界时返回false
+func (idx *index) pick(value interface{}) (interface{}, bool) {
+	if list, ok := value.([]map[string]interface{}); ok {
+		lo, hi, ok := idx.bounds(len(list))
+		if !ok {
+			return nil, false
+		}
+		if !idx.slice {
+			return list[lo], true
+		}
+		return list[lo:hi], true
+	}
+	values, ok := toInterfaces(value)
+	if !ok {
+		return nil, false
+	}
+	lo, hi, ok := idx.bounds(len(values))
+	if !ok {
+		return nil, false
+	}
+	if !idx.slice {
+		return values[lo], true
+	}
+	return values[lo:hi], true
+}
+
+//splitPath 将mapper中的路径按照.切分，方括号中的内容作为筛选条件编译
+func splitPath(text string) ([]segment, error) {
+	var res []segment
+	runes := []rune(text)
+	start := 0
+	for i := 0; i <= len(runes); i++ {
+		if i < len(runes) && runes[i] != '.' && runes[i] != '[' {
+			continue
+		}
+		key := string(runes[start:i])
+		if key == "" {
+			return nil, fmt.Errorf("path %s: empty field name at %d", text, i)
+		}
+		seg := segment{key: key}
+		for i < len(runes) && runes[i] == '[' {
+			end, err := closingBracket(runes, i)
+			if err != nil {
+				return nil, fmt.Errorf("path %s: %v", text, err)
+			}
+			if err := seg.bracket(string(runes[i+1 : end])); err != nil {
+				return nil, fmt.Errorf("path %s: %v", text, err)
+			}
+			i = end + 1
+		}
+		if i < len(runes) && runes[i] != '.' {
+			return nil, fmt.Errorf("path %s: '.' expected at %d", text, i)
+		}
+		res = append(res, seg)
+		start = i + 1
+	}
+	return res, nil
+}
+
+//bracket 将方括号中的内容解析为下标、切片或者筛选条件
+func (seg *segment) bracket(text string) error {
+	if seg.index != nil {
+		return fmt.Errorf("unexpected [%s] after index of %s", text, seg.key)
+	}
+	if idx, ok := parseIndex(text); ok {
+		seg.index = idx
+		return nil
+	}
+	if seg.filter != nil {
+		return fmt.Errorf("%s has more than one filter", seg.key)
+	}
+	filter, err := compileExpr(text)
+	if err != nil {
+		return fmt.Errorf("filter %s: %v", text, err)
+	}
+	seg.filter = filter
+	return nil
+}
+
+//closingBracket 返回与runes[open]处的左方括号对应的右方括号的位置，忽略字符串中的方括号
+func closingBracket(runes []rune, open int) (int, error) {
+	depth := 0
+	var quote rune
+	for i := open; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			if r == '\\' {
+				i++
+			} else if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '[':
+			depth++
+		case r == ']':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unclosed '[' at %d", open)
+}
+
+//segmentKeys 返回路径中每一段的字段名
+func segmentKeys(segs []segment) []string {
+	res := make([]string, 0, len(segs))
+	for _, seg := range segs {
+		res = append(res, seg.key)
+	}
+	return res
+}
+
+//segmentsPath 返回去掉下标与筛选条件后的路径，例如 datas[0].name 返回 datas.name
+func segmentsPath(segs []segment) string {
+	return strings.Join(segmentKeys(segs), ".")
+}
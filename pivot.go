@@ -0,0 +1,78 @@
+package datamapper
+
+import "fmt"
+
+//pivot 将对象数组转换为以key为键、value为值的对象，value为fanOut时对每一个元素分别转换
+//key为null的元素会被跳过，重复的key以最后一个元素为准
+func (m *mapping) pivot(value interface{}, r *rule) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case fanOut:
+		res := make(fanOut, 0, len(v))
+		for _, elem := range v {
+			res = append(res, m.pivot(elem, r))
+		}
+		return res
+	case map[string]interface{}:
+		// 单个对象视为只有一个元素的数组
+		return m.pivot([]map[string]interface{}{v}, r)
+	case []map[string]interface{}:
+		e := m.env(r)
+		res := make(map[string]interface{}, len(v))
+		for i, elem := range v {
+			key, _, _ := getSourceData(e, elem, r.pivot.key)
+			if key == nil {
+				m.report(&MappingError{
+					SourcePath: fmt.Sprintf("%s[%d].%s", r.source, i, segmentsPath(r.pivot.key)),
+					TargetPath: r.target,
+					Err:        fmt.Errorf("pivot key is missing"),
+				})
+				continue
+			}
+			val, _, _ := getSourceData(e, elem, r.pivot.value)
+			res[str(key)] = val
+		}
+		return res
+	}
+	m.report(&MappingError{SourcePath: r.source, TargetPath: r.target, Expected: "[]object", Value: value})
+	return skip{}
+}
+
+//unpivot 将对象的每一个键值对依次写入目标数组元素中的key与value路径，数组的长度与键值对的个数一致
+//键值对按照key排序，保证每次转换的结果一致
+func (m *mapping) unpivot(targetMap map[string]*interface{}, target *complexType, value interface{}, r *rule) {
+	keys, values, ok := m.unpivotValue(value, r)
+	if !ok {
+		return
+	}
+	m.setTargetData(targetMap, target, r.unpivot.keyRule.targetPaths, keys, r.unpivot.keyRule)
+	m.setTargetData(targetMap, target, r.unpivot.valueRule.targetPaths, values, r.unpivot.valueRule)
+}
+
+//unpivotValue 将对象拆分为key与value两个fanOut，value为fanOut时对每一个元素分别拆分
+func (m *mapping) unpivotValue(value interface{}, r *rule) (interface{}, interface{}, bool) {
+	switch v := value.(type) {
+	case nil, skip:
+		return nil, nil, false
+	case fanOut:
+		keys, values := make(fanOut, 0, len(v)), make(fanOut, 0, len(v))
+		for _, elem := range v {
+			k, val, ok := m.unpivotValue(elem, r)
+			if !ok {
+				k, val = fanOut{}, fanOut{}
+			}
+			keys, values = append(keys, k), append(values, val)
+		}
+		return keys, values, true
+	case map[string]interface{}:
+		names := sortedMapKeys(v)
+		keys, values := make(fanOut, 0, len(v)), make(fanOut, 0, len(v))
+		for _, name := range names {
+			keys, values = append(keys, name), append(values, v[name])
+		}
+		return keys, values, true
+	}
+	m.report(&MappingError{SourcePath: r.source, TargetPath: r.target, Expected: "object", Value: value})
+	return nil, nil, false
+}
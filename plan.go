@@ -0,0 +1,965 @@
+package datamapper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//Plan 由DataDefine编译得到的映射计划
+//Plan在编译完成后不再修改，Transform可以被多个goroutine并发调用
+type Plan struct {
+	unmarshal TypeUnmarshalHandle
+	marshal   TypeMarshalHandle
+	source    *complexType
+	target    *complexType
+	rules     []*rule
+	lookups   map[string]*lookupTable
+	//passthrough 不为nil时复制没有被使用的输入字段
+	passthrough *passthrough
+	//params 声明的运行时参数，没有声明时为nil，传入的参数原样使用
+	params *complexType
+	//seq seq函数使用的序列，属于编译Plan的DataDefine
+	seq *sequence
+}
+
+//complexType 编译后的复杂类型，所有字段的typeRef都已被解析
+type complexType struct {
+	name   string
+	fields map[string]*field
+	//keys 排序后的字段名，保证每次转换时字段的处理顺序一致
+	keys []string
+	//variants 不为nil时为oneOf的候选类型，fields为所有候选类型字段的并集，同名字段以排在前面的类型为准
+	variants []*complexType
+	names    []string
+	//discriminator 区分候选类型的字段路径，values为其取值对应的候选类型下标
+	discriminator []segment
+	values        map[string]int
+}
+
+//field 编译后的字段
+type field struct {
+	name string
+	//spec 为DataDefine中规格的副本，编译后对DataDefine的修改不会影响Plan
+	spec DataSpec
+	//complex 当字段为complex时指向引用的复杂类型
+	complex *complexType
+	//def 转换后的默认值，没有声明或者无法转换时为nil
+	def interface{}
+	//entry 为true时表示由map的key、*或$key得到的字段，map中的对象可以通过$key取得对应的key
+	entry bool
+}
+
+const (
+	//wildcardKey 路径中map字段之后的*，按照key的顺序遍历map中的每一个值
+	wildcardKey = "*"
+	//mapKey 路径中map字段之后的$key，按照相同的顺序取出map中的每一个key
+	mapKey = "$key"
+	//variantKey 路径中oneOf字段之后的$variant，取出源数据匹配的候选类型名称
+	variantKey = "$variant"
+	//paramsKey 源路径中引用WithParams传入的参数，例如 $params.tenant
+	paramsKey = "$params"
+)
+
+//rule 编译后的映射规则，source和target的路径已经预先切分，source路径中的筛选条件已经编译
+type rule struct {
+	source      string
+	target      string
+	sourcePaths []segment
+	targetPaths []segment
+	transforms  []*step
+	//expr 计算字段的表达式，不为nil时source为表达式的原文，值由表达式求得而不是从源数据中读取
+	expr expr
+	//constant 为true时expr为constants中声明的常量
+	constant bool
+	//regenerate 为true时表达式中包含uuid、seq等函数，写入数组的每一个元素时分别求值
+	regenerate bool
+	//when 映射的条件，fallback为条件不成立时使用的值
+	when     expr
+	fallback expr
+	//pivot与unpivot为行列转换时元素中key与value的路径
+	pivot   *pivot
+	unpivot *pivot
+}
+
+//pivot 编译后的行列转换规则
+type pivot struct {
+	key   []segment
+	value []segment
+	//keyRule与valueRule为unpivot时写入目标数组元素中key与value的规则
+	keyRule   *rule
+	valueRule *rule
+}
+
+//compiler 负责将DataDefine中的typeRef解析为complexType
+type compiler struct {
+	define *DataDefine
+	types  map[string]*complexType
+	//unions 等待合并字段的oneOf类型，自引用的候选类型需要在所有类型填充完成后才能合并
+	unions []*complexType
+}
+
+func newCompiler(define *DataDefine) *compiler {
+	return &compiler{
+		define: define,
+		types:  make(map[string]*complexType),
+	}
+}
+
+//root 编译source或target等根节点的定义
+func (c *compiler) root(complexDefine ComplexDefine) *complexType {
+	ct := &complexType{fields: make(map[string]*field)}
+	c.fill(ct, complexDefine)
+	for _, u := range c.unions {
+		u.merge()
+	}
+	c.unions = nil
+	return ct
+}
+
+//source 编译source的定义，并加入引用运行时参数的$params字段
+//$params只存在于fields中，不会从输入数据中读取
+func (c *compiler) source() *complexType {
+	ct := c.root(c.define.Source)
+	f := &field{name: paramsKey, spec: DataSpec{Type: "any"}}
+	if len(c.define.Params) > 0 {
+		f.spec = DataSpec{Type: "complex"}
+		f.complex = c.root(c.define.Params)
+	}
+	ct.fields[paramsKey] = f
+	return ct
+}
+
+//union 生成oneOf字段对应的复杂类型，候选类型的字段在root中合并
+func (c *compiler) union(spec *DataSpec) *complexType {
+	ct := &complexType{name: strings.Join(spec.OneOf, "|"), fields: make(map[string]*field), values: make(map[string]int)}
+	for i, name := range spec.OneOf {
+		ct.variants = append(ct.variants, c.resolve(name))
+		ct.names = append(ct.names, name)
+		ct.values[name] = i
+	}
+	for value, name := range spec.Variants {
+		if i, ok := ct.values[name]; ok {
+			ct.values[value] = i
+		}
+	}
+	if spec.Discriminator != "" {
+		// 无效的路径由Validate报告，转换时按照结构选择
+		ct.discriminator, _ = splitTargetPath(spec.Discriminator)
+	}
+	c.unions = append(c.unions, ct)
+	return ct
+}
+
+//merge 合并所有候选类型的字段
+func (ct *complexType) merge() {
+	for _, v := range ct.variants {
+		for _, key := range v.keys {
+			if _, ok := ct.fields[key]; !ok {
+				ct.fields[key] = v.fields[key]
+				ct.keys = append(ct.keys, key)
+			}
+		}
+	}
+	sort.Strings(ct.keys)
+}
+
+//variant 返回对象匹配的候选类型下标
+//声明了discriminator时按照其取值选择，否则选择第一个包含对象所有字段且对象中不缺少必填字段的类型
+func (ct *complexType) variant(obj map[string]interface{}) (int, error) {
+	if ct.discriminator != nil {
+		value, _, _ := getSourceData(nil, obj, ct.discriminator)
+		if value == nil {
+			return 0, fmt.Errorf("%w: discriminator %s is missing", ErrNoVariant, segmentsPath(ct.discriminator))
+		}
+		i, ok := ct.values[str(value)]
+		if !ok {
+			return 0, fmt.Errorf("%w: discriminator %s is %v", ErrNoVariant, segmentsPath(ct.discriminator), value)
+		}
+		return i, nil
+	}
+	for i, v := range ct.variants {
+		if v.matches(obj) {
+			return i, nil
+		}
+	}
+	return 0, ErrNoVariant
+}
+
+//matches 判断对象的所有字段都在ct中声明，并且ct中的必填字段都存在
+func (ct *complexType) matches(obj map[string]interface{}) bool {
+	for key := range obj {
+		if _, ok := ct.fields[key]; !ok {
+			return false
+		}
+	}
+	for _, key := range ct.keys {
+		f := ct.fields[key]
+		if _, ok := obj[key]; !ok && f.spec.IsRequired() && f.def == nil {
+			return false
+		}
+	}
+	return true
+}
+
+//resolve 解析typeRef对应的复杂类型，同名类型只会编译一次
+//typeRef不存在时返回一个没有字段的复杂类型
+func (c *compiler) resolve(name string) *complexType {
+	if ct, ok := c.types[name]; ok {
+		return ct
+	}
+	ct := &complexType{name: name, fields: make(map[string]*field)}
+	// 先注册再填充字段，使得自引用的类型可以找到自身
+	c.types[name] = ct
+	c.fill(ct, c.define.Complex[name])
+	return ct
+}
+
+func (c *compiler) fill(ct *complexType, complexDefine ComplexDefine) {
+	for key, def := range complexDefine {
+		if def == nil {
+			continue
+		}
+		f := &field{name: key, spec: copySpec(def)}
+		// 无法转换的默认值由Validate报告，转换时忽略
+		f.def, _ = defaultValue(def)
+		// map的typeRef不是简单类型时，值为对应的复杂类型
+		if def.IsComplex() || (def.IsMap() && !simpleTypeRefs[def.TypeRef]) {
+			f.complex = c.resolve(def.TypeRef)
+		}
+		if def.IsOneOf() {
+			f.complex = c.union(&f.spec)
+		}
+		ct.fields[key] = f
+		ct.keys = append(ct.keys, key)
+	}
+	sort.Strings(ct.keys)
+}
+
+//copySpec 复制字段的规格，编译后修改DataDefine中的Scale、OneOf与Variants不会影响已经编译的Plan
+func copySpec(def *DataSpec) DataSpec {
+	spec := *def
+	if def.Scale != nil {
+		scale := *def.Scale
+		spec.Scale = &scale
+	}
+	if def.OneOf != nil {
+		spec.OneOf = append([]string(nil), def.OneOf...)
+	}
+	if def.Variants != nil {
+		spec.Variants = make(map[string]string, len(def.Variants))
+		for value, name := range def.Variants {
+			spec.Variants[value] = name
+		}
+	}
+	return spec
+}
+
+//rules 按source路径排序后生成映射规则，保证每次转换的执行顺序一致
+//存在无法编译的规则时返回第一个错误，其余的规则仍然会被返回
+func (c *compiler) rules() ([]*rule, error) {
+	mapper, first := c.mapper()
+	sources := make([]string, 0, len(mapper))
+	for source := range mapper {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	res := make([]*rule, 0, len(sources))
+	for _, source := range sources {
+		r, err := compileRule(source, mapper[source])
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		res = append(res, r)
+	}
+
+	// 层级相同时计算字段排在mapper之后，使得表达式的结果可以覆盖直接映射的值
+	for _, target := range sortedStringKeys(c.define.Computed) {
+		r, err := compileComputed(target, c.define.Computed[target])
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		res = append(res, r)
+	}
+
+	// 常量排在最后，不依赖源数据
+	for _, target := range sortedMapKeys(c.define.Constants) {
+		r, err := compileConstant(target, c.define.Constants[target])
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		res = append(res, r)
+	}
+
+	// 结果经过数组层级较多的规则先写入，使得每一层数组的长度在单个值广播到数组元素之前就已经确定
+	source := c.source()
+	depths := make(map[*rule]int, len(res))
+	for _, r := range res {
+		depths[r] = source.ruleDepth(r)
+		// 结果经过数组的表达式由Validate报告
+		r.regenerate = r.expr != nil && depths[r] == 0 && volatileFunc(r.expr) != ""
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return depths[res[i]] > depths[res[j]]
+	})
+	return res, first
+}
+
+//compileRule 编译mapper中的一条规则
+func compileRule(source string, mr *MapperRule) (*rule, error) {
+	if mr == nil {
+		mr = &MapperRule{}
+	}
+	sourcePaths, err := splitPath(source)
+	if err != nil {
+		return nil, fmt.Errorf("mapper %s: %v", source, err)
+	}
+	targetPaths, err := splitTargetPath(mr.Target)
+	if err != nil {
+		return nil, fmt.Errorf("mapper %s: %v", source, err)
+	}
+	r := &rule{
+		source:      source,
+		target:      mr.Target,
+		sourcePaths: sourcePaths,
+		targetPaths: targetPaths,
+	}
+	for _, text := range mr.Transform {
+		s, err := parseStep(text)
+		if err != nil {
+			return nil, fmt.Errorf("mapper %s: %v", source, err)
+		}
+		r.transforms = append(r.transforms, s)
+	}
+	if mr.When != "" {
+		x, err := compileExpr(mr.When)
+		if err != nil {
+			return nil, fmt.Errorf("mapper %s: when: %v", source, err)
+		}
+		r.when = x
+	}
+	if mr.Pivot != nil && mr.Unpivot != nil {
+		return nil, fmt.Errorf("mapper %s: pivot and unpivot can't be used together", source)
+	}
+	if mr.Pivot != nil {
+		if r.pivot, err = compilePivot(mr.Pivot); err != nil {
+			return nil, fmt.Errorf("mapper %s: pivot: %v", source, err)
+		}
+	}
+	if mr.Unpivot != nil {
+		if r.unpivot, err = compilePivot(mr.Unpivot); err != nil {
+			return nil, fmt.Errorf("mapper %s: unpivot: %v", source, err)
+		}
+		r.unpivot.keyRule = r.elementRule(mr.Unpivot.Key, r.unpivot.key)
+		r.unpivot.valueRule = r.elementRule(mr.Unpivot.Value, r.unpivot.value)
+	}
+	if mr.Fallback != "" {
+		if r.when == nil {
+			return nil, fmt.Errorf("mapper %s: fallback requires when", source)
+		}
+		x, err := compileExpr(mr.Fallback)
+		if err != nil {
+			return nil, fmt.Errorf("mapper %s: fallback: %v", source, err)
+		}
+		r.fallback = x
+	}
+	return r, nil
+}
+
+//location 返回规则在规格中的位置，用于错误信息
+func (r *rule) location() string {
+	if r.constant {
+		return "constant " + r.target
+	}
+	if r.expr != nil {
+		return "computed " + r.target
+	}
+	return "mapper " + r.source
+}
+
+//exprs 返回规则中的所有表达式，包括transform的参数
+func (r *rule) exprs() []expr {
+	res := []expr{r.expr, r.when, r.fallback}
+	for _, s := range r.transforms {
+		res = append(res, s.args...)
+	}
+	return res
+}
+
+//elementRule 返回写入目标数组元素中path路径的规则
+func (r *rule) elementRule(path string, segs []segment) *rule {
+	er := *r
+	er.target = r.target + "." + path
+	er.targetPaths = append(append([]segment{}, r.targetPaths...), segs...)
+	return &er
+}
+
+//fieldRule 返回写入复杂类型中key字段的规则，错误信息中仍然使用原规则的目标路径
+func (r *rule) fieldRule(key string) *rule {
+	fr := *r
+	fr.targetPaths = append(append(make([]segment, 0, len(r.targetPaths)+1), r.targetPaths...), segment{key: key})
+	return &fr
+}
+
+//compilePivot 编译行列转换规则中key与value的路径
+func compilePivot(pr *PivotRule) (*pivot, error) {
+	if pr.Key == "" || pr.Value == "" {
+		return nil, fmt.Errorf("key and value are required")
+	}
+	key, err := splitTargetPath(pr.Key)
+	if err != nil {
+		return nil, err
+	}
+	value, err := splitTargetPath(pr.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &pivot{key: key, value: value}, nil
+}
+
+//compileComputed 编译computed中的一条计算字段
+func compileComputed(target, source string) (*rule, error) {
+	x, err := compileExpr(source)
+	if err != nil {
+		return nil, fmt.Errorf("computed %s: %v", target, err)
+	}
+	targetPaths, err := splitTargetPath(target)
+	if err != nil {
+		return nil, fmt.Errorf("computed %s: %v", target, err)
+	}
+	return &rule{
+		source:      source,
+		target:      target,
+		targetPaths: targetPaths,
+		expr:        x,
+	}, nil
+}
+
+//compileConstant 编译constants中的一个常量
+func compileConstant(target string, value interface{}) (*rule, error) {
+	targetPaths, err := splitTargetPath(target)
+	if err != nil {
+		return nil, fmt.Errorf("constant %s: %v", target, err)
+	}
+	return &rule{
+		target:      target,
+		targetPaths: targetPaths,
+		expr:        &literal{value: yamlValue(value)},
+		constant:    true,
+	}, nil
+}
+
+//splitTargetPath 切分目标路径，目标路径中只支持下标与切片
+func splitTargetPath(target string) ([]segment, error) {
+	segs, err := splitPath(target)
+	if err != nil {
+		return nil, err
+	}
+	for _, seg := range segs {
+		if seg.filter != nil {
+			return nil, fmt.Errorf("path %s: filter is not supported in target path", target)
+		}
+	}
+	return segs, nil
+}
+
+//Compile 将DataDefine编译为不可变的Plan
+//编译时会解析所有的typeRef并预先切分mapper中的路径，同一个Plan可以被多个goroutine同时使用
+func Compile(d *DataDefine) (*Plan, error) {
+	if d == nil {
+		return nil, fmt.Errorf("data define is nil")
+	}
+	unmarshal, ok := SourceTypeDefine[d.SourceType]
+	if !ok {
+		return nil, fmt.Errorf("sourceType not any of them: %v", sourceTypes())
+	}
+	marshal, ok := TargetTypeDefine[d.TargetType]
+	if !ok {
+		return nil, fmt.Errorf("targetType not any of them: %v", targetTypes())
+	}
+
+	c := newCompiler(d)
+	rules, err := c.rules()
+	if err != nil {
+		return nil, err
+	}
+	lookups, err := c.lookups()
+	if err != nil {
+		return nil, err
+	}
+	pass, err := compilePassthrough(d.Passthrough, rules)
+	if err != nil {
+		return nil, err
+	}
+	p := &Plan{
+		unmarshal: unmarshal,
+		marshal:   marshal,
+		source:    c.source(),
+		target:    c.root(d.Target),
+		rules:     rules,
+		lookups:   lookups,
+		seq:       &d.seq,
+
+		passthrough: pass,
+	}
+	p.params = p.source.fields[paramsKey].complex
+	for _, r := range p.rules {
+		for _, name := range lookupNames(r) {
+			if _, ok := lookups[name]; !ok {
+				return nil, fmt.Errorf("%s: lookup table %s is not defined", r.location(), name)
+			}
+		}
+		if p.target.lookup(segmentKeys(r.targetPaths)) == nil {
+			return nil, fmt.Errorf("%s: target path %s is not defined", r.location(), r.target)
+		}
+	}
+	return p, nil
+}
+
+//Transform 将输入数据转换为源数据，再将源数据转换为目标数据
+//默认情况下无法转换的值只记录日志，可以通过WithStrict或WithMultiError改为返回错误
+func (p *Plan) Transform(input []byte, opts ...Option) ([]byte, error) {
+	var inputMap map[string]interface{}
+	if err := p.unmarshal(input, &inputMap); err != nil {
+		logger.Warn("parse input data error: ", err)
+		return nil, err
+	}
+
+	m := newMapping(opts...)
+	m.lookups = p.lookups
+	m.gen.seq = p.seq
+	sourceMap := m.parseSource(p.source, inputMap, "", 0)
+	sourceMap[paramsKey] = m.parseParams(p.params)
+	targetMap := generateMap(p.target)
+	m.mapRules(p.rules, sourceMap, p.target, targetMap)
+	if p.passthrough != nil {
+		m.passthrough(p.passthrough, inputMap, p.target, targetMap)
+	}
+	if err := m.err(); err != nil {
+		return nil, err
+	}
+	return p.marshal(targetMap)
+}
+
+//parseParams 按照声明转换WithParams传入的参数，缺失的参数使用默认值，必填的参数缺失时报告ErrRequired
+//没有声明参数时原样返回传入的参数
+func (m *mapping) parseParams(ct *complexType) map[string]interface{} {
+	params, _ := paramValue(m.params).(map[string]interface{})
+	if ct == nil {
+		return params
+	}
+	return m.parseSource(ct, params, paramsKey, 0)
+}
+
+//lookup 根据路径查找字段，路径不存在时返回nil
+func (ct *complexType) lookup(paths []string) *field {
+	if len(paths) == 0 {
+		return nil
+	}
+	f, ok := ct.fields[paths[0]]
+	if !ok {
+		return nil
+	}
+	if len(paths) == 1 {
+		return f
+	}
+	if f.complex == nil {
+		return nil
+	}
+	return f.complex.lookup(paths[1:])
+}
+
+//ruleDepth 返回规则的结果经过的对象数组层级，计算字段与常量为表达式中引用的路径的最大层级
+func (ct *complexType) ruleDepth(r *rule) int {
+	if r.expr != nil {
+		return ct.exprDepth(r.expr)
+	}
+	return ct.arrayDepth(r.sourcePaths)
+}
+
+//exprDepth 返回表达式结果的数组层级，聚合函数的结果为单个值
+func (ct *complexType) exprDepth(x expr) int {
+	var children []expr
+	switch n := x.(type) {
+	case *pathRef:
+		return ct.arrayDepth(n.segs)
+	case *unary:
+		children = []expr{n.x}
+	case *binary:
+		children = []expr{n.x, n.y}
+	case *conditional:
+		children = []expr{n.c, n.a, n.b}
+	case *call:
+		if n.fn.aggregate {
+			return 0
+		}
+		children = n.args
+	}
+	depth := 0
+	for _, child := range children {
+		if d := ct.exprDepth(child); d > depth {
+			depth = d
+		}
+	}
+	return depth
+}
+
+//arrayDepth 统计路径经过的对象数组层级，通过下标取出单个元素的数组不计入层级
+func (ct *complexType) arrayDepth(segs []segment) int {
+	depth := 0
+	for i, f := range walkFields(ct, nil, segmentKeys(segs)) {
+		if f.spec.IsArray() && (f.complex != nil || f.entry) && (segs[i].index == nil || segs[i].index.slice) {
+			depth++
+		}
+	}
+	return depth
+}
+
+//walkFields 沿路径查找每一段对应的字段，prev不为nil时路径相对于prev字段的值查找
+//map字段之后的一段可以是任意key、*或者$key，路径无效时只返回有效的部分
+func walkFields(ct *complexType, prev *field, paths []string) []*field {
+	res := make([]*field, 0, len(paths))
+	for _, key := range paths {
+		var f *field
+		switch {
+		case prev == nil:
+			f = ct.fields[key]
+		case prev.spec.IsMap():
+			f = prev.mapField(key)
+		case prev.spec.IsAny():
+			// any的结构由数据决定，其中的任意路径都可以引用
+			f = &field{name: key, spec: DataSpec{Type: "any"}}
+		case key == variantKey && prev.complex != nil && prev.complex.variants != nil:
+			f = &field{name: key, spec: DataSpec{Type: "simple", TypeRef: "string"}}
+		case key == mapKey && prev.entry && prev.complex != nil:
+			f = &field{name: key, spec: DataSpec{Type: "simple", TypeRef: "string"}}
+		case prev.complex != nil:
+			f = prev.complex.fields[key]
+		}
+		if f == nil {
+			break
+		}
+		res = append(res, f)
+		prev = f
+	}
+	return res
+}
+
+//mapField 返回map字段中key对应的值的字段，key为*时为值组成的数组，为$key时为key组成的数组
+func (f *field) mapField(key string) *field {
+	if key == mapKey {
+		return &field{name: key, spec: DataSpec{Type: "simple", TypeRef: "string", Multiple: "true"}, entry: true}
+	}
+	spec := DataSpec{Type: "simple", TypeRef: f.spec.TypeRef, Nullable: f.spec.Nullable, Scale: f.spec.Scale,
+		Layout: f.spec.Layout, Timezone: f.spec.Timezone}
+	if f.complex != nil {
+		spec.Type = "complex"
+	}
+	if key == wildcardKey {
+		spec.Multiple = "true"
+	}
+	return &field{name: key, spec: spec, complex: f.complex, entry: true}
+}
+
+func sourceTypes() []string {
+	res := make([]string, 0, len(SourceTypeDefine))
+	for define := range SourceTypeDefine {
+		res = append(res, define)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func targetTypes() []string {
+	res := make([]string, 0, len(TargetTypeDefine))
+	for define := range TargetTypeDefine {
+		res = append(res, define)
+	}
+	sort.Strings(res)
+	return res
+}
+
+//mapping 单次转换的运行状态，每次转换都会创建新的实例，因此Plan本身不需要加锁
+type mapping struct {
+	options
+	errs MultiError
+	//root 本次转换的源数据，供表达式与transform参数中的路径引用
+	root map[string]interface{}
+	//written 已经写入值的目标路径，用于检查required的目标字段
+	written map[string]bool
+	lookups map[string]*lookupTable
+	gen     generator
+}
+
+func newMapping(opts ...Option) *mapping {
+	m := &mapping{options: newOptions(opts)}
+	m.gen = generator{now: m.now, random: m.random}
+	return m
+}
+
+//report 记录转换过程中的错误，lenient模式下只输出日志
+func (m *mapping) report(err *MappingError) {
+	if m.mode == lenient {
+		logger.Warn(err)
+		return
+	}
+	m.errs = append(m.errs, err)
+}
+
+//err 返回本次转换的错误，strict模式下只返回第一个错误
+func (m *mapping) err() error {
+	if len(m.errs) == 0 {
+		return nil
+	}
+	if m.mode == strict {
+		return m.errs[0]
+	}
+	return m.errs
+}
+
+//mapRules 依次执行映射规则，将源数据写入目标数据
+func (m *mapping) mapRules(rules []*rule, sourceMap map[string]interface{}, target *complexType, targetMap map[string]*interface{}) {
+	m.root = sourceMap
+	m.written = make(map[string]bool)
+	for _, r := range rules {
+		var (
+			value interface{} = deferred{}
+			ok                = true
+		)
+		if !r.regenerate {
+			value, ok = m.sourceValue(r)
+		}
+		if r.when != nil {
+			// 源数据缺失时条件成立则跳过，条件不成立时仍然可以写入fallback
+			if !ok {
+				value = skip{}
+			}
+			value, ok = m.applyWhen(value, r)
+		}
+		if !ok {
+			continue
+		}
+		if r.pivot != nil {
+			value = m.pivot(value, r)
+		}
+		value = m.applyTransforms(value, r)
+		if r.unpivot != nil {
+			m.unpivot(targetMap, target, value, r)
+			continue
+		}
+		m.setTargetData(targetMap, target, r.targetPaths, value, r)
+	}
+	for _, path := range requiredPaths(target) {
+		if !m.written[path] {
+			m.report(&MappingError{TargetPath: path, Err: ErrRequired})
+		}
+	}
+}
+
+//requiredPaths 返回声明为required且没有默认值的目标字段路径
+func requiredPaths(ct *complexType) []string {
+	var res []string
+	seen := make(map[*complexType]bool)
+	var walk func(ct *complexType, path string)
+	walk = func(ct *complexType, path string) {
+		// 自引用的类型只检查一次
+		if seen[ct] {
+			return
+		}
+		seen[ct] = true
+		for _, key := range ct.keys {
+			f := ct.fields[key]
+			switch {
+			case f.complex != nil:
+				// map的key与oneOf的字段由数据决定，不检查其中的必填字段
+				if !f.spec.IsMap() && f.complex.variants == nil {
+					walk(f.complex, joinPath(path, key))
+				}
+			case f.spec.IsRequired() && f.def == nil:
+				res = append(res, joinPath(path, key))
+			}
+		}
+		delete(seen, ct)
+	}
+	walk(ct, "")
+	return res
+}
+
+//sourceValue 读取规则对应的源数据，计算字段则对表达式求值
+func (m *mapping) sourceValue(r *rule) (interface{}, bool) {
+	var (
+		value interface{}
+		ok    = true
+		err   error
+	)
+	if r.expr == nil {
+		value, ok, err = getSourceData(m.env(r), m.root, r.sourcePaths)
+	} else {
+		value, err = r.expr.eval(m.env(r))
+	}
+	if err != nil {
+		m.report(&MappingError{
+			SourcePath: r.source,
+			TargetPath: r.target,
+			Err:        err,
+		})
+		return nil, false
+	}
+	return value, ok
+}
+
+//skip 条件不成立且没有fallback时代替原来的值，写入目标时会被忽略
+type skip struct{}
+
+//applyWhen 根据规则的条件筛选value，条件为fanOut时按元素分别选择value、fallback或者跳过
+func (m *mapping) applyWhen(value interface{}, r *rule) (interface{}, bool) {
+	e := m.env(r)
+	cond, err := r.when.eval(e)
+	if err != nil {
+		m.report(&MappingError{SourcePath: r.source, TargetPath: r.target, Err: fmt.Errorf("when: %w", err)})
+		return nil, false
+	}
+	var fallback interface{} = skip{}
+	if r.fallback != nil {
+		if fallback, err = r.fallback.eval(e); err != nil {
+			m.report(&MappingError{SourcePath: r.source, TargetPath: r.target, Err: fmt.Errorf("fallback: %w", err)})
+			return nil, false
+		}
+	}
+	res, err := vectorize([]interface{}{cond, value, fallback}, func(args []interface{}) (interface{}, error) {
+		if truthy(args[0]) {
+			return args[1], nil
+		}
+		return args[2], nil
+	})
+	if err != nil {
+		m.report(&MappingError{SourcePath: r.source, TargetPath: r.target, Err: fmt.Errorf("when: %w", err)})
+		return nil, false
+	}
+	if _, ok := res.(skip); ok {
+		return nil, false
+	}
+	return res, true
+}
+
+//env 返回执行规则r时表达式与函数使用的环境
+func (m *mapping) env(r *rule) *env {
+	return &env{
+		root: m.root,
+		ctx:  &FuncContext{SourcePath: r.source, TargetPath: r.target, lookups: m.lookups, gen: &m.gen},
+	}
+}
+
+//convert 将value转换为spec声明的简单类型，转换失败时报告错误
+//数据格式错误时与早期版本保持一致，返回该类型的零值
+func (m *mapping) convert(value interface{}, spec *DataSpec, sourcePath, targetPath string) (interface{}, bool) {
+	if value == nil {
+		if spec.IsNullable() {
+			return nil, true
+		}
+		m.mismatch(value, spec, sourcePath, targetPath)
+		return nil, false
+	}
+	v, err := convertSimple(value, spec)
+	if err != nil {
+		m.report(&MappingError{
+			SourcePath: sourcePath,
+			TargetPath: targetPath,
+			Expected:   spec.TypeRef,
+			Value:      value,
+			Err:        err,
+		})
+	}
+	return v, v != nil
+}
+
+//convertSlice 逐个转换数组中的元素，转换失败的元素使用零值占位以保持数组长度不变
+func (m *mapping) convertSlice(values []interface{}, spec *DataSpec, sourcePath, targetPath string) interface{} {
+	res := make([]interface{}, 0, len(values))
+	for i, value := range values {
+		if value == nil && !spec.IsNullable() {
+			res = append(res, zeroValue(spec))
+			continue
+		}
+		v, ok := m.convert(value, spec, fmt.Sprintf("%s[%d]", sourcePath, i), targetPath)
+		if !ok {
+			v = zeroValue(spec)
+		}
+		res = append(res, v)
+	}
+	return makeSimpleSlice(res, spec)
+}
+
+//convertMap 将对象中的每一个值转换为map声明的类型，无法转换的键值对会被丢弃
+func (m *mapping) convertMap(obj map[string]interface{}, spec *DataSpec, sourcePath, targetPath string) map[string]interface{} {
+	elem := *spec
+	elem.Type = "simple"
+	res := make(map[string]interface{}, len(obj))
+	for key, value := range obj {
+		path := sourcePath + "." + key
+		if value == nil {
+			if spec.IsNullable() {
+				res[key] = nil
+			} else {
+				m.mismatch(value, &elem, path, targetPath)
+			}
+			continue
+		}
+		if v, ok := m.convert(value, &elem, path, targetPath); ok {
+			res[key] = v
+		}
+	}
+	return res
+}
+
+//mismatch 报告值的结构与规格不一致的错误
+func (m *mapping) mismatch(value interface{}, spec *DataSpec, sourcePath, targetPath string) {
+	m.report(&MappingError{
+		SourcePath: sourcePath,
+		TargetPath: targetPath,
+		Expected:   expectedType(spec),
+		Value:      value,
+	})
+}
+
+//cardinality 报告数组写入单个值的字段，调用方在宽松模式下继续使用第一个元素
+func (m *mapping) cardinality(value interface{}, spec *DataSpec, sourcePath, targetPath string) {
+	if list, ok := value.(fanOut); ok {
+		value = []interface{}(list)
+	}
+	m.report(&MappingError{
+		SourcePath: sourcePath,
+		TargetPath: targetPath,
+		Expected:   expectedType(spec),
+		Value:      value,
+		Err:        ErrCardinality,
+	})
+}
+
+//expectedType 返回规格声明的类型描述，数组类型使用[]前缀，map类型使用map[string]前缀，oneOf列出所有候选类型
+func expectedType(spec *DataSpec) string {
+	if spec.IsMap() {
+		return "map[string]" + spec.TypeRef
+	}
+	if spec.IsAny() {
+		return "any"
+	}
+	typeRef := spec.TypeRef
+	if spec.IsOneOf() {
+		typeRef = "oneOf(" + strings.Join(spec.OneOf, "|") + ")"
+	}
+	if spec.IsArray() {
+		return "[]" + typeRef
+	}
+	return typeRef
+}
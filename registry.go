@@ -0,0 +1,236 @@
+package datamapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//FuncContext 自定义函数被调用时的上下文，用于在错误中定位问题
+type FuncContext struct {
+	//SourcePath 当前规则的源路径，计算字段为表达式的原文
+	SourcePath string
+	//TargetPath 当前规则的目标路径
+	TargetPath string
+
+	//lookups 当前规格中声明的转换表
+	lookups map[string]*lookupTable
+	//gen 生成函数使用的时钟、随机数来源与序列
+	gen *generator
+}
+
+var (
+	registryMu sync.RWMutex
+	//registry 通过RegisterFunc注册的自定义函数
+	registry = map[string]*function{}
+
+	funcContextType = reflect.TypeOf((*FuncContext)(nil))
+	errorType       = reflect.TypeOf((*error)(nil)).Elem()
+	numberType      = reflect.TypeOf(json.Number(""))
+	timeType        = reflect.TypeOf(time.Time{})
+)
+
+//RegisterFunc 注册可以在transform与表达式中调用的自定义函数
+//fn必须是函数，第一个参数可以声明为*FuncContext用于获取当前规则的路径，其余参数支持
+//string、bool、整数、浮点数、json.Number、time.Time与interface{}，最后一个参数可以是可变参数。
+//返回值为一个值，或者一个值与error。调用时参数会按照声明的类型进行转换，转换失败时作为转换错误报告。
+//函数在Compile时解析，因此需要在编译使用该函数的规格之前注册；不能与内置函数或已注册的函数重名
+//注册在整个进程中永久有效，不能注销或者替换，通常在init中注册
+func RegisterFunc(name string, fn interface{}) error {
+	if !isFuncName(name) {
+		return fmt.Errorf("register func %q: invalid function name", name)
+	}
+	if _, ok := builtins[name]; ok {
+		return fmt.Errorf("register func %s: conflicts with a builtin function", name)
+	}
+	f, err := reflectFunc(name, fn)
+	if err != nil {
+		return fmt.Errorf("register func %s: %v", name, err)
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		return fmt.Errorf("register func %s: already registered", name)
+	}
+	registry[name] = f
+	return nil
+}
+
+//isFuncName 判断name能否在表达式中作为函数名使用
+func isFuncName(name string) bool {
+	tokens, err := tokenize(name)
+	if err != nil || len(tokens) != 2 || tokens[0].kind != tokenIdent {
+		return false
+	}
+	switch name {
+	case "true", "false", "null":
+		return false
+	}
+	return true
+}
+
+//reflectFunc 检查fn的签名并将其包装为function
+func reflectFunc(name string, fn interface{}) (*function, error) {
+	v := reflect.ValueOf(fn)
+	if fn == nil || v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("expected a function, got %s", typeName(fn))
+	}
+	t := v.Type()
+
+	// 第一个参数为*FuncContext时由调用方传入，不计入参数个数
+	offset := 0
+	if t.NumIn() > 0 && t.In(0) == funcContextType {
+		offset = 1
+	}
+	params := make([]reflect.Type, 0, t.NumIn()-offset)
+	for i := offset; i < t.NumIn(); i++ {
+		param := t.In(i)
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			param = param.Elem()
+		}
+		if !isArgType(param) {
+			return nil, fmt.Errorf("unsupported argument type %s", t.In(i))
+		}
+		params = append(params, param)
+	}
+
+	switch {
+	case t.NumOut() == 1 && isArgType(t.Out(0)):
+	case t.NumOut() == 2 && isArgType(t.Out(0)) && t.Out(1) == errorType:
+	default:
+		return nil, fmt.Errorf("must return a value or a value and an error")
+	}
+
+	f := &function{name: name, minArgs: len(params), maxArgs: len(params)}
+	if t.IsVariadic() {
+		f.minArgs, f.maxArgs = len(params)-1, -1
+	}
+	f.call = func(ctx *FuncContext, args []interface{}) (interface{}, error) {
+		in := make([]reflect.Value, 0, len(args)+offset)
+		if offset > 0 {
+			if ctx == nil {
+				ctx = &FuncContext{}
+			}
+			in = append(in, reflect.ValueOf(ctx))
+		}
+		for i, arg := range args {
+			param := params[len(params)-1]
+			if i < len(params) {
+				param = params[i]
+			}
+			a, err := argValue(arg, param)
+			if err != nil {
+				return nil, fmt.Errorf("argument %d: %v", i+1, err)
+			}
+			in = append(in, a)
+		}
+
+		out := v.Call(in)
+		if len(out) == 2 && !out[1].IsNil() {
+			return nil, out[1].Interface().(error)
+		}
+		return resultValue(out[0]), nil
+	}
+	return f, nil
+}
+
+//isArgType 判断自定义函数能否使用该类型的参数与返回值
+func isArgType(t reflect.Type) bool {
+	if t == numberType || t == timeType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Interface:
+		return t.NumMethod() == 0
+	}
+	return false
+}
+
+//argValue 将参数转换为自定义函数声明的类型
+func argValue(arg interface{}, t reflect.Type) (reflect.Value, error) {
+	if t.Kind() == reflect.Interface {
+		if arg == nil {
+			return reflect.Zero(t), nil
+		}
+		return reflect.ValueOf(arg), nil
+	}
+	if arg == nil {
+		return reflect.Value{}, fmt.Errorf("null can't be used as %s", t)
+	}
+
+	var (
+		v   interface{}
+		err error
+	)
+	switch {
+	case t == numberType:
+		v, err = toDecimal(arg, nil)
+	case t == timeType:
+		v, err = toDateTime(arg, &DataSpec{})
+	case t.Kind() == reflect.String:
+		v, err = toString(arg)
+	case t.Kind() == reflect.Bool:
+		v, err = toBoolean(arg)
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		v, err = toNumber(arg)
+	default:
+		v, err = toInteger(arg)
+	}
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	res := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := v.(int64)
+		if res.OverflowInt(i) {
+			return reflect.Value{}, fmt.Errorf("%d overflows %s", i, t)
+		}
+		res.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i := v.(int64)
+		if i < 0 || res.OverflowUint(uint64(i)) {
+			return reflect.Value{}, fmt.Errorf("%d overflows %s", i, t)
+		}
+		res.SetUint(uint64(i))
+	default:
+		res.Set(reflect.ValueOf(v).Convert(t))
+	}
+	return res, nil
+}
+
+//resultValue 将自定义函数的返回值转换为转换过程中使用的类型
+func resultValue(v reflect.Value) interface{} {
+	if v.Type() == numberType || v.Type() == timeType {
+		return v.Interface()
+	}
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return v.Elem().Interface()
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		// uint64可能超出int64的范围，使用json.Number保证不丢失精度
+		return json.Number(strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	}
+	return v.Interface()
+}
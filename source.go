@@ -0,0 +1,300 @@
+package datamapper
+
+import (
+	"fmt"
+	"sort"
+)
+
+//fanOut 源路径经过对象数组时得到的结果，每个元素对应数组中的一个对象
+//与简单类型数组的值区分开，用于在目标中按层级展开数组
+type fanOut []interface{}
+
+//parseSource 根据编译后的复杂类型将inputMap转换为源数据，path为inputMap在输入数据中的路径
+//depth为inputMap的嵌套层级，根对象为0
+func (m *mapping) parseSource(ct *complexType, inputMap map[string]interface{}, path string, depth int) map[string]interface{} {
+	res := make(map[string]interface{})
+
+	// 从复合类型定义中获取各个字段的定义
+	for _, key := range ct.keys {
+		f := ct.fields[key]
+		// 从复合类型的名称取出inputMap的数据
+		inValue, ok := inputMap[key]
+		if !ok { //不存在时使用默认值，没有默认值则跳过
+			if f.def != nil {
+				res[key] = f.def
+			} else if f.spec.IsRequired() {
+				m.report(&MappingError{SourcePath: joinPath(path, key), Err: ErrRequired})
+			}
+			continue
+		}
+		if v, ok := m.parseField(f, inValue, joinPath(path, key), depth); ok {
+			res[key] = v
+		}
+	}
+	return res
+}
+
+//parseField 根据字段定义转换单个输入值，返回false表示该值无法被转换，depth为字段所在对象的嵌套层级
+func (m *mapping) parseField(f *field, inValue interface{}, path string, depth int) (interface{}, bool) {
+	spec := &f.spec
+	if spec.IsAny() {
+		return inValue, true
+	}
+	if inValue == nil {
+		if spec.IsNullable() {
+			return nil, true
+		}
+		m.mismatch(inValue, spec, path, "")
+		return nil, false
+	}
+	values, isSlice := toInterfaces(inValue)
+
+	if spec.IsMap() {
+		obj, ok := inValue.(map[string]interface{})
+		if !ok {
+			m.mismatch(inValue, spec, path, "")
+			return nil, false
+		}
+		if f.complex == nil {
+			return m.convertMap(obj, spec, path, ""), true
+		}
+		if depth >= m.maxDepth {
+			m.report(&MappingError{SourcePath: path, Err: ErrMaxDepth})
+			return nil, false
+		}
+		res := make(map[string]interface{}, len(obj))
+		for key, v := range obj {
+			elemPath := joinPath(path, key)
+			elem, ok := v.(map[string]interface{})
+			if !ok {
+				if v == nil && spec.IsNullable() {
+					res[key] = nil
+				} else {
+					m.mismatch(v, spec, elemPath, "")
+				}
+				continue
+			}
+			// 对象中保留对应的key，使得遍历map时可以通过$key取得
+			value := m.parseSource(f.complex, elem, elemPath, depth+1)
+			value[mapKey] = key
+			res[key] = value
+		}
+		return res, true
+	}
+
+	if spec.IsComplex() || spec.IsOneOf() {
+		if depth >= m.maxDepth {
+			m.report(&MappingError{SourcePath: path, Err: ErrMaxDepth})
+			return nil, false
+		}
+		if spec.IsArray() {
+			if !isSlice {
+				// 输入为单个对象时转换为只有一个元素的数组
+				values = []interface{}{inValue}
+			}
+			slim := make([]map[string]interface{}, 0, len(values))
+			for i, v := range values {
+				elemPath := fmt.Sprintf("%s[%d]", path, i)
+				elem, ok := v.(map[string]interface{})
+				if !ok {
+					m.mismatch(v, spec, elemPath, "")
+					continue
+				}
+				if obj, ok := m.parseObject(f, elem, elemPath, depth+1); ok {
+					slim = append(slim, obj)
+				}
+			}
+			return slim, true
+		}
+		if isSlice {
+			// 需要单个对象而输入为数组时报告错误，宽松模式下取第一个元素
+			m.cardinality(inValue, spec, path, "")
+			if len(values) == 0 {
+				return nil, true
+			}
+			inValue = values[0]
+		}
+		elem, ok := inValue.(map[string]interface{})
+		if !ok {
+			m.mismatch(inValue, spec, path, "")
+			return nil, false
+		}
+		return m.parseObject(f, elem, path, depth+1)
+	}
+
+	if spec.IsSimple() {
+		if spec.IsArray() {
+			if !isSlice {
+				values = []interface{}{inValue}
+			}
+			if res := m.convertSlice(values, spec, path, ""); res != nil {
+				return res, true
+			}
+			return nil, false
+		}
+		if isSlice {
+			m.cardinality(inValue, spec, path, "")
+			if len(values) == 0 {
+				return nil, true
+			}
+			inValue = values[0]
+		}
+		return m.convert(inValue, spec, path, "")
+	}
+	return nil, false
+}
+
+//parseObject 将对象转换为字段引用的复杂类型，oneOf字段先选择匹配的候选类型并记录在$variant中
+//没有匹配的候选类型时返回false
+func (m *mapping) parseObject(f *field, obj map[string]interface{}, path string, depth int) (map[string]interface{}, bool) {
+	ct := f.complex
+	if ct.variants == nil {
+		return m.parseSource(ct, obj, path, depth), true
+	}
+	i, err := ct.variant(obj)
+	if err != nil {
+		m.report(&MappingError{SourcePath: path, Err: err})
+		return nil, false
+	}
+	res := m.parseSource(ct.variants[i], obj, path, depth)
+	res[variantKey] = ct.names[i]
+	return res, true
+}
+
+//getSourceData 根据路径从源数据中取值，路径不存在时返回false
+//路径经过对象数组时返回fanOut，每个元素为数组中对应对象的取值结果，不存在的元素取值为nil
+//带有筛选条件的数组只保留条件成立的元素，e为筛选条件求值时的环境
+//带有下标的数组只取出对应的元素，切片则保留对应范围内的元素
+func getSourceData(e *env, sourceMap map[string]interface{}, segs []segment) (interface{}, bool, error) {
+	if len(segs) == 0 {
+		return nil, false, nil
+	}
+	//从path中获取对应的value值
+	val, ok := sourceMap[segs[0].key]
+	switch {
+	case ok:
+	case segs[0].key == wildcardKey:
+		val = mapValues(sourceMap)
+	case segs[0].key == mapKey:
+		keys := sortedMapKeys(sourceMap)
+		list := make(fanOut, 0, len(keys))
+		for _, key := range keys {
+			list = append(list, key)
+		}
+		val = list
+	default:
+		// 搜寻路径中不存在对应的值
+		return nil, false, nil
+	}
+	if segs[0].filter != nil {
+		var err error
+		if val, ok, err = filterValue(e, val, segs[0].filter); !ok || err != nil {
+			return nil, false, err
+		}
+	}
+	if segs[0].index != nil {
+		if val, ok = segs[0].index.pick(val); !ok {
+			return nil, false, nil
+		}
+	}
+	if len(segs) == 1 {
+		return val, true, nil
+	}
+
+	switch val := val.(type) {
+	case map[string]interface{}:
+		return getSourceData(e, val, segs[1:])
+	case []map[string]interface{}:
+		res := make(fanOut, 0, len(val))
+		for _, m := range val {
+			v, _, err := getSourceData(e, m, segs[1:])
+			if err != nil {
+				return nil, false, err
+			}
+			res = append(res, v)
+		}
+		return res, true, nil
+	case fanOut:
+		res := make(fanOut, 0, len(val))
+		for _, elem := range val {
+			var v interface{}
+			if m, ok := elem.(map[string]interface{}); ok {
+				var err error
+				if v, _, err = getSourceData(e, m, segs[1:]); err != nil {
+					return nil, false, err
+				}
+			}
+			res = append(res, v)
+		}
+		return res, true, nil
+	}
+	return nil, false, nil
+}
+
+//mapValues 按照key的顺序返回map中的值，值都为对象时返回对象数组，与数组一样可以筛选和取下标
+func mapValues(obj map[string]interface{}) interface{} {
+	keys := sortedMapKeys(obj)
+	objs := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		o, ok := obj[key].(map[string]interface{})
+		if !ok {
+			break
+		}
+		objs = append(objs, o)
+	}
+	if len(objs) == len(keys) {
+		return objs
+	}
+	res := make(fanOut, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, obj[key])
+	}
+	return res
+}
+
+//sortedMapKeys 返回排序后的key，保证遍历map的顺序一致
+func sortedMapKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//filterValue 使用筛选条件过滤对象数组中的元素，单个对象不满足条件时返回false
+func filterValue(e *env, val interface{}, filter expr) (interface{}, bool, error) {
+	match := func(m map[string]interface{}) (bool, error) {
+		cond, err := filter.eval(&env{root: e.root, scope: m, ctx: e.ctx})
+		if err != nil {
+			return false, fmt.Errorf("filter: %w", err)
+		}
+		return truthy(cond), nil
+	}
+	switch val := val.(type) {
+	case map[string]interface{}:
+		ok, err := match(val)
+		return val, ok, err
+	case []map[string]interface{}:
+		res := make([]map[string]interface{}, 0, len(val))
+		for _, m := range val {
+			ok, err := match(m)
+			if err != nil {
+				return nil, false, err
+			}
+			if ok {
+				res = append(res, m)
+			}
+		}
+		return res, true, nil
+	}
+	return nil, false, fmt.Errorf("filter: %s is not an object array", typeName(val))
+}
+
+//joinPath 拼接源数据中的路径
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,413 @@
+package datamapper
+
+//generateMap 根据编译后的复杂类型生成对应的map[string]*interface
+//对应生成的map，如果存在数组则会自动包含一个元素
+//自引用的复杂类型只生成一层，更深的层级在写入数据时按需生成
+func generateMap(ct *complexType) map[string]*interface{} {
+	return generateNested(ct, map[*complexType]bool{})
+}
+
+//generateNested 生成ct对应的map，parents为正在生成的外层类型，用于发现自引用
+func generateNested(ct *complexType, parents map[*complexType]bool) map[string]*interface{} {
+	// oneOf的对象只包含写入过的字段
+	if ct.variants != nil {
+		return make(map[string]*interface{})
+	}
+	parents[ct] = true
+	defer delete(parents, ct)
+	res := make(map[string]*interface{})
+	for key, f := range ct.fields {
+		if v, ok := generateValue(f, parents); ok {
+			res[key] = &v
+		}
+	}
+	return res
+}
+
+//generateValue 生成字段的初始值，声明了default时使用默认值，未知的简单类型返回false
+//引用了外层类型的复杂字段生成空数组或null，避免无限展开
+func generateValue(f *field, parents map[*complexType]bool) (interface{}, bool) {
+	spec := &f.spec
+	if spec.IsComplex() {
+		if parents[f.complex] {
+			if spec.IsArray() {
+				return []map[string]*interface{}{}, true
+			}
+			return nil, true
+		}
+		if spec.IsArray() {
+			return []map[string]*interface{}{generateNested(f.complex, parents)}, true
+		}
+		return generateNested(f.complex, parents), true
+	}
+	if spec.IsOneOf() {
+		// 候选类型由写入的数据决定，数组不包含默认的元素
+		if spec.IsArray() {
+			return []map[string]*interface{}{}, true
+		}
+		return generateNested(f.complex, parents), true
+	}
+	if spec.IsMap() {
+		return map[string]interface{}{}, true
+	}
+	if spec.IsAny() {
+		return nil, true
+	}
+	if spec.IsSimple() {
+		zero := zeroValue(spec)
+		if zero == nil {
+			return nil, false
+		}
+		if f.def != nil {
+			return outputValue(f.def, spec), true
+		}
+		// datetime没有合适的零值，未被映射时与允许为null的字段一样输出null
+		if spec.IsNullable() || spec.IsDateTime() {
+			return nil, true
+		}
+		if spec.IsArray() {
+			return makeSimpleSlice([]interface{}{zero}, spec), true
+		}
+		return zero, true
+	}
+	return nil, false
+}
+
+//setTargetData 将value写入targetMap中paths指向的位置
+//路径经过对象数组时，fanOut的每个元素依次写入数组的对应元素，数组的长度与fanOut保持一致
+//路径中带有下标或切片时只写入对应的元素，写入的位置超出数组长度时会扩展数组
+func (m *mapping) setTargetData(targetMap map[string]*interface{}, ct *complexType, paths []segment, value interface{}, r *rule) {
+	if len(paths) == 0 {
+		return
+	}
+	seg := paths[0]
+	f, ok := ct.fields[seg.key]
+	if !ok {
+		return
+	}
+	slot, ok := ct.slot(targetMap, seg.key)
+	if !ok {
+		return
+	}
+	if len(paths) == 1 {
+		if seg.index != nil && f.spec.IsArray() {
+			m.setIndexedLeaf(slot, f, seg.index, value, r)
+			return
+		}
+		m.setLeaf(slot, f, value, r)
+		return
+	}
+
+	if *slot == nil && f.complex != nil {
+		// 自引用类型中没有展开的层级，写入时才生成
+		if f.spec.IsArray() {
+			*slot = []map[string]*interface{}{}
+		} else {
+			*slot = generateMap(f.complex)
+		}
+	}
+	switch val := (*slot).(type) {
+	case map[string]*interface{}:
+		m.setTargetData(val, f.complex, paths[1:], value, r)
+	case []map[string]*interface{}:
+		if seg.index != nil {
+			*slot = m.setIndexed(val, f, seg.index, paths[1:], value, r)
+			return
+		}
+		list, ok := value.(fanOut)
+		if !ok {
+			// 单个值写入数组中的每一个元素
+			for _, elem := range val {
+				m.setTargetData(elem, f.complex, paths[1:], value, r)
+			}
+			return
+		}
+		// 扩展或截断对象数组，使其长度与源数据一致
+		if len(val) > len(list) {
+			val = val[:len(list)]
+		}
+		for len(val) < len(list) {
+			val = append(val, generateMap(f.complex))
+		}
+		*slot = val
+		for i, elem := range val {
+			m.setTargetData(elem, f.complex, paths[1:], list[i], r)
+		}
+	}
+}
+
+//setIndexed 将value写入对象数组中下标或切片对应的元素，切片时fanOut的元素依次写入切片中的元素
+func (m *mapping) setIndexed(val []map[string]*interface{}, f *field, idx *index, paths []segment, value interface{}, r *rule) []map[string]*interface{} {
+	list, isFanOut := value.(fanOut)
+	count := -1
+	if isFanOut && idx.slice {
+		count = len(list)
+	}
+	lo, hi, ok := idx.targetBounds(len(val), count)
+	if !ok {
+		return val
+	}
+	for len(val) < hi {
+		val = append(val, generateMap(f.complex))
+	}
+	for i := lo; i < hi; i++ {
+		v := value
+		if count >= 0 {
+			if i-lo >= count {
+				break
+			}
+			v = list[i-lo]
+		}
+		m.setTargetData(val[i], f.complex, paths, v, r)
+	}
+	return val
+}
+
+//setIndexedLeaf 将value写入简单类型数组中下标或切片对应的元素
+func (m *mapping) setIndexedLeaf(slot *interface{}, f *field, idx *index, value interface{}, r *rule) {
+	spec := &f.spec
+	if _, ok := value.(skip); ok {
+		return
+	}
+	if _, ok := value.(deferred); ok {
+		if value, ok = m.sourceValue(r); !ok {
+			return
+		}
+	}
+	items, isSlice := toInterfaces(value)
+	if isSlice && !idx.slice {
+		if _, ok := value.(fanOut); !ok {
+			m.mismatch(value, spec, r.source, r.target)
+			return
+		}
+		// 与setLeaf一致，多个值写入单个元素时报告错误，宽松模式下取第一个元素
+		if len(items) > 1 {
+			m.cardinality(value, spec, r.source, r.target)
+		}
+		if len(items) == 0 {
+			return
+		}
+		value, items, isSlice = items[0], nil, false
+	}
+	count := -1
+	if isSlice {
+		count = len(items)
+	}
+
+	current, _ := toInterfaces(*slot)
+	values := append([]interface{}{}, current...)
+	lo, hi, ok := idx.targetBounds(len(values), count)
+	if !ok {
+		return
+	}
+	var fill interface{}
+	if !spec.IsNullable() && !spec.IsDateTime() {
+		fill = zeroValue(spec)
+	}
+	for len(values) < hi {
+		values = append(values, fill)
+	}
+	for i := lo; i < hi; i++ {
+		v := value
+		if isSlice {
+			if i-lo >= count {
+				break
+			}
+			v = items[i-lo]
+		}
+		if v == nil {
+			if spec.IsNullable() {
+				values[i] = nil
+			}
+			continue
+		}
+		if c, ok := m.convert(v, spec, r.source, r.target); ok {
+			values[i] = outputValue(c, spec)
+		}
+	}
+	*slot = makeSimpleSlice(values, spec)
+	m.written[segmentsPath(r.targetPaths)] = true
+}
+
+//setLeaf 将value转换为字段声明的类型后写入slot
+func (m *mapping) setLeaf(slot *interface{}, f *field, value interface{}, r *rule) {
+	spec := &f.spec
+	if _, ok := value.(skip); ok {
+		return
+	}
+	if _, ok := value.(deferred); ok {
+		if value, ok = m.sourceValue(r); !ok {
+			return
+		}
+	}
+	if spec.IsAny() {
+		*slot = rawValue(value)
+		m.written[segmentsPath(r.targetPaths)] = true
+		return
+	}
+	if list, ok := value.(fanOut); ok && !spec.IsArray() {
+		// 经过筛选的对象数组只有一个元素时写入该元素，多个元素需要通过[0]等下标选择，宽松模式下取第一个元素
+		// 没有元素时保留默认值
+		if len(list) > 1 {
+			m.cardinality(value, spec, r.source, r.target)
+		}
+		if len(list) == 0 {
+			return
+		}
+		value = list[0]
+	}
+	if value == nil {
+		// 源数据为null或数组元素中不存在对应的值时，不允许为null的字段保留默认值
+		if spec.IsNullable() {
+			*slot = nil
+			m.written[segmentsPath(r.targetPaths)] = true
+		}
+		return
+	}
+	if obj, ok := value.(map[string]interface{}); ok && spec.IsMap() {
+		var res map[string]interface{}
+		if f.complex != nil {
+			res = m.copyEntries(f, obj, r)
+		} else {
+			res = m.convertMap(obj, spec, r.source, r.target)
+			for key, v := range res {
+				res[key] = outputValue(v, spec)
+			}
+		}
+		*slot = res
+		m.written[segmentsPath(r.targetPaths)] = true
+		return
+	}
+	if spec.IsComplex() || spec.IsOneOf() {
+		m.setComplex(slot, f, value, r, len(r.targetPaths))
+		return
+	}
+	if !spec.IsSimple() {
+		m.mismatch(value, spec, r.source, r.target)
+		return
+	}
+	values, isSlice := toInterfaces(value)
+	if spec.IsArray() {
+		if !isSlice {
+			values = []interface{}{value}
+		}
+		if res := m.convertSlice(values, spec, r.source, r.target); res != nil {
+			*slot = outputValue(res, spec)
+			m.written[segmentsPath(r.targetPaths)] = true
+		}
+		return
+	}
+	if isSlice {
+		m.mismatch(value, spec, r.source, r.target)
+		return
+	}
+	if v, ok := m.convert(value, spec, r.source, r.target); ok {
+		*slot = outputValue(v, spec)
+		m.written[segmentsPath(r.targetPaths)] = true
+	}
+}
+
+//setComplex 将源数据中的对象按照字段名复制到复杂类型的字段中，depth为slot所在的嵌套层级
+//自引用的类型按照数据的实际层级展开，超过最大深度的部分会被丢弃
+func (m *mapping) setComplex(slot *interface{}, f *field, value interface{}, r *rule, depth int) {
+	if depth > m.maxDepth {
+		m.report(&MappingError{SourcePath: r.source, TargetPath: r.target, Err: ErrMaxDepth})
+		return
+	}
+	var objs []map[string]interface{}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		objs = []map[string]interface{}{v}
+	case []map[string]interface{}:
+		objs = v
+	case fanOut, []interface{}:
+		// any类型的数组中的元素也可以是对象
+		elems, _ := toInterfaces(v)
+		for _, elem := range elems {
+			if obj, ok := elem.(map[string]interface{}); ok {
+				objs = append(objs, obj)
+			} else if elem != nil {
+				m.mismatch(elem, &f.spec, r.source, r.target)
+			}
+		}
+	default:
+		m.mismatch(value, &f.spec, r.source, r.target)
+		return
+	}
+
+	if !f.spec.IsArray() {
+		// 数组写入单个对象时报告错误，宽松模式下取第一个元素
+		if len(objs) > 1 {
+			m.cardinality(value, &f.spec, r.source, r.target)
+		}
+		if len(objs) > 0 {
+			*slot = m.copyObject(f.complex, objs[0], r, depth)
+			m.written[segmentsPath(r.targetPaths)] = true
+		}
+		return
+	}
+	res := make([]map[string]*interface{}, 0, len(objs))
+	for _, obj := range objs {
+		res = append(res, m.copyObject(f.complex, obj, r, depth))
+	}
+	*slot = res
+	m.written[segmentsPath(r.targetPaths)] = true
+}
+
+//copyEntries 将对象中的每一个值复制为map字段声明的复杂类型
+func (m *mapping) copyEntries(f *field, obj map[string]interface{}, r *rule) map[string]interface{} {
+	res := make(map[string]interface{}, len(obj))
+	for key, v := range obj {
+		elem, ok := v.(map[string]interface{})
+		if !ok {
+			if v == nil && f.spec.IsNullable() {
+				res[key] = nil
+			} else {
+				m.mismatch(v, &f.spec, r.source, r.target)
+			}
+			continue
+		}
+		res[key] = m.copyObject(f.complex, elem, r, len(r.targetPaths)+1)
+	}
+	return res
+}
+
+//copyObject 生成ct对应的map，并写入obj中同名字段的值
+func (m *mapping) copyObject(ct *complexType, obj map[string]interface{}, r *rule, depth int) map[string]*interface{} {
+	res := generateMap(ct)
+	for _, key := range ct.keys {
+		value, ok := obj[key]
+		if !ok {
+			continue
+		}
+		slot, ok := ct.slot(res, key)
+		if !ok {
+			continue
+		}
+		// 按照子字段的路径记录写入，源对象中缺少的必填字段仍然会被检查
+		fr := r.fieldRule(key)
+		if f := ct.fields[key]; (f.spec.IsComplex() || f.spec.IsOneOf()) && value != nil {
+			m.setComplex(slot, f, value, fr, depth+1)
+		} else {
+			m.setLeaf(slot, f, value, fr)
+		}
+	}
+	return res
+}
+
+//slot 返回targetMap中key对应的位置，oneOf的对象中只包含写入过的字段，第一次写入时生成
+func (ct *complexType) slot(targetMap map[string]*interface{}, key string) (*interface{}, bool) {
+	if slot, ok := targetMap[key]; ok {
+		return slot, true
+	}
+	f, ok := ct.fields[key]
+	if !ok || ct.variants == nil {
+		return nil, false
+	}
+	v, ok := generateValue(f, map[*complexType]bool{ct: true})
+	if !ok {
+		return nil, false
+	}
+	targetMap[key] = &v
+	return &v, true
+}
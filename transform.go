@@ -0,0 +1,100 @@
+package datamapper
+
+import "fmt"
+
+//step transform管道中的一个步骤，例如 trim 或 padLeft(8, "0")
+type step struct {
+	text string
+	fn   *function
+	//args 声明的参数，调用时排在管道传入的值之后，参数可以是任意表达式
+	args []expr
+}
+
+//parseStep 解析transform中的一个步骤
+func parseStep(text string) (*step, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, fmt.Errorf("transform %q: %v", text, err)
+	}
+	p := &parser{src: []rune(text), tokens: tokens}
+	name := p.next()
+	if name.kind != tokenIdent {
+		return nil, fmt.Errorf("transform %q: function name expected", text)
+	}
+	s := &step{text: text}
+	if s.fn = lookupFunc(name.text); s.fn == nil {
+		return nil, fmt.Errorf("transform %q: function %s is not defined", text, name.text)
+	}
+	if p.accept("(") {
+		if s.args, err = p.parseArgs(); err != nil {
+			return nil, fmt.Errorf("transform %q: %v", text, err)
+		}
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("transform %q: unexpected %q at %d", text, t.text, t.pos)
+	}
+	if err := s.fn.checkArgs(len(s.args) + 1); err != nil {
+		return nil, fmt.Errorf("transform %q: %v", text, err)
+	}
+	return s, nil
+}
+
+//call 以value作为第一个参数调用函数
+func (s *step) call(value interface{}, e *env) (interface{}, error) {
+	args := make([]interface{}, 0, len(s.args)+1)
+	args = append(args, value)
+	for _, arg := range s.args {
+		v, err := arg.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	return s.fn.invoke(e.ctx, args)
+}
+
+//applyTransforms 依次执行规则中的transform
+//value为数组或fanOut时对其中的每一个元素分别执行，为对象时对其中的每一个值分别执行
+//null值与被条件跳过的值不会传入函数
+func (m *mapping) applyTransforms(value interface{}, r *rule) interface{} {
+	if _, ok := value.(skip); ok || len(r.transforms) == 0 || value == nil {
+		return value
+	}
+	if list, ok := value.(fanOut); ok {
+		res := make(fanOut, 0, len(list))
+		for _, v := range list {
+			res = append(res, m.applyTransforms(v, r))
+		}
+		return res
+	}
+	if obj, ok := value.(map[string]interface{}); ok {
+		res := make(map[string]interface{}, len(obj))
+		for key, v := range obj {
+			res[key] = m.applyTransforms(v, r)
+		}
+		return res
+	}
+	if values, ok := toInterfaces(value); ok {
+		res := make([]interface{}, 0, len(values))
+		for _, v := range values {
+			res = append(res, m.applyTransforms(v, r))
+		}
+		return res
+	}
+
+	e := m.env(r)
+	for _, s := range r.transforms {
+		v, err := s.call(value, e)
+		if err != nil {
+			m.report(&MappingError{
+				SourcePath: r.source,
+				TargetPath: r.target,
+				Value:      value,
+				Err:        fmt.Errorf("transform %s: %w", s.text, err),
+			})
+			return nil
+		}
+		value = v
+	}
+	return value
+}
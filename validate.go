@@ -0,0 +1,646 @@
+package datamapper
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+var (
+	//simpleTypeRefs type为simple时typeRef支持的取值
+	simpleTypeRefs = map[string]bool{
+		"number":   true,
+		"string":   true,
+		"boolean":  true,
+		"integer":  true,
+		"decimal":  true,
+		"datetime": true,
+	}
+)
+
+//SpecError 规格校验时发现的问题
+type SpecError struct {
+	//Path 问题在yaml中的位置，例如 complex.property.desc.typeRef
+	Path string
+	//Line 问题在yaml中的行号，DataDefine不是由GenerateDataDefine生成时为0
+	Line    int
+	Message string
+}
+
+//Error 实现error接口
+func (e *SpecError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", e.Line, e.Path, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+//validator 收集DataDefine中的所有问题
+type validator struct {
+	define   *DataDefine
+	compiler *compiler
+	root     *yamlv3.Node
+	problems []*SpecError
+	//copied 复杂类型整体复制时写入的子字段路径
+	copied map[string]bool
+}
+
+//Validate 静态检查DataDefine，返回发现的所有问题，没有问题时返回nil
+//检查内容包括无法解析的typeRef、mapper中不存在的路径、重复的target、不支持的type/typeRef取值
+//以及source与target之间数组层级不匹配
+func (d *DataDefine) Validate() []*SpecError {
+	v := &validator{define: d, compiler: newCompiler(d), copied: make(map[string]bool)}
+	if len(d.raw) > 0 {
+		var doc yamlv3.Node
+		if err := yamlv3.Unmarshal(d.raw, &doc); err == nil && len(doc.Content) > 0 {
+			v.root = doc.Content[0]
+		}
+	}
+
+	if _, ok := SourceTypeDefine[d.SourceType]; !ok {
+		v.report([]string{"sourceType"}, "sourceType %q not any of them: %v", d.SourceType, sourceTypes())
+	}
+	if _, ok := TargetTypeDefine[d.TargetType]; !ok {
+		v.report([]string{"targetType"}, "targetType %q not any of them: %v", d.TargetType, targetTypes())
+	}
+	v.checkDefine([]string{"source"}, d.Source)
+	v.checkDefine([]string{"target"}, d.Target)
+	v.checkDefine([]string{"params"}, d.Params)
+	for _, name := range sortedKeys(d.Complex) {
+		v.checkDefine([]string{"complex", name}, d.Complex[name])
+	}
+	targets := v.checkMapper()
+	v.checkComputed(targets)
+	v.checkConstants(targets)
+	v.checkRequired(targets)
+	v.checkPassthrough()
+
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		return v.problems[i].Line < v.problems[j].Line
+	})
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return v.problems
+}
+
+func (v *validator) checkDefine(location []string, complexDefine ComplexDefine) {
+	keys := make([]string, 0, len(complexDefine))
+	for key := range complexDefine {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v.checkSpec(append(location, key), complexDefine[key])
+	}
+}
+
+func (v *validator) checkSpec(location []string, spec *DataSpec) {
+	at := func(key string) []string {
+		return append(append([]string{}, location...), key)
+	}
+	if spec == nil {
+		v.report(location, "data spec is empty")
+		return
+	}
+	switch {
+	case spec.IsSimple():
+		if !simpleTypeRefs[spec.TypeRef] {
+			v.report(at("typeRef"), "unsupported simple typeRef %q", spec.TypeRef)
+		}
+	case spec.IsComplex():
+		if _, ok := v.define.Complex[spec.TypeRef]; !ok {
+			v.report(at("typeRef"), "typeRef %q is not defined in complex", spec.TypeRef)
+		}
+	case spec.IsMap():
+		if _, ok := v.define.Complex[spec.TypeRef]; !ok && !simpleTypeRefs[spec.TypeRef] {
+			v.report(at("typeRef"), "map typeRef %q is neither a simple typeRef nor defined in complex", spec.TypeRef)
+		}
+		if spec.IsArray() {
+			v.report(at("multiple"), "map can't be multiple")
+		}
+	case spec.IsOneOf():
+		v.checkOneOf(location, spec)
+	case spec.IsAny():
+		if spec.IsArray() {
+			v.report(at("multiple"), "any can't be multiple, arrays are kept as they are")
+		}
+	default:
+		v.report(at("type"), "unsupported type %q", spec.Type)
+	}
+	if !spec.IsOneOf() && (spec.Discriminator != "" || len(spec.Variants) > 0) {
+		v.report(location, "discriminator and variants are only supported on oneOf")
+	}
+	if !isFlag(spec.Multiple) {
+		v.report(at("multiple"), "multiple must be true or false, got %q", spec.Multiple)
+	}
+	if spec.Scale != nil && (!spec.IsDecimal() || *spec.Scale < 0) {
+		v.report(at("scale"), "scale must be a non-negative integer on a decimal typeRef")
+	}
+	if (spec.Layout != "" || spec.Timezone != "") && !spec.IsDateTime() {
+		v.report(location, "layout and timezone are only supported on a datetime typeRef")
+	}
+	if _, err := loadLocation(spec.Timezone); err != nil {
+		v.report(at("timezone"), "%v", err)
+	}
+	if !isFlag(spec.Nullable) {
+		v.report(at("nullable"), "nullable must be true or false, got %q", spec.Nullable)
+	}
+	if !isFlag(spec.Required) {
+		v.report(at("required"), "required must be true or false, got %q", spec.Required)
+	}
+	if _, err := defaultValue(spec); err != nil {
+		v.report(at("default"), "invalid default: %v", err)
+	}
+}
+
+//checkOneOf 检查oneOf的候选类型以及discriminator
+func (v *validator) checkOneOf(location []string, spec *DataSpec) {
+	at := func(key string) []string {
+		return append(append([]string{}, location...), key)
+	}
+	if len(spec.OneOf) == 0 {
+		v.report(at("oneOf"), "oneOf requires at least one complex type")
+	}
+	listed := make(map[string]bool, len(spec.OneOf))
+	for _, name := range spec.OneOf {
+		listed[name] = true
+		if _, ok := v.define.Complex[name]; !ok {
+			v.report(at("oneOf"), "oneOf type %q is not defined in complex", name)
+		}
+	}
+	for _, value := range sortedStringKeys(spec.Variants) {
+		if name := spec.Variants[value]; !listed[name] {
+			v.report(at("variants"), "variant %s refers to %q which is not listed in oneOf", value, name)
+		}
+	}
+	if spec.Discriminator == "" {
+		return
+	}
+	segs, err := splitTargetPath(spec.Discriminator)
+	if err != nil {
+		v.report(at("discriminator"), "%v", err)
+		return
+	}
+	for _, name := range spec.OneOf {
+		if !listed[name] || v.define.Complex[name] == nil {
+			continue
+		}
+		f, _, problem := walkPath(v.compiler.resolve(name), segmentKeys(segs))
+		if problem == "" && (!f.spec.IsSimple() || f.spec.IsArray()) {
+			problem = "not a single simple field"
+		}
+		if problem != "" {
+			v.report(at("discriminator"), "discriminator %s in %s: %s", spec.Discriminator, name, problem)
+		}
+	}
+}
+
+//checkMapper 检查mapper中的规则，返回已经映射的目标路径与对应的源路径
+//通配符规则展开后逐条检查，与显式声明的规则冲突的字段会被跳过
+func (v *validator) checkMapper() map[string]string {
+	sourceRoot := v.compiler.source()
+	targetRoot := v.compiler.root(v.define.Target)
+	targets := make(map[string]string)
+	explicit := make(map[string]bool)
+	for source, mr := range v.define.Mapper {
+		if mr != nil && !isWildcard(source, mr) {
+			explicit[mr.Target] = true
+		}
+	}
+
+	for _, source := range sortedRuleKeys(v.define.Mapper) {
+		location := []string{"mapper", source}
+		mr := v.define.Mapper[source]
+		if !isWildcard(source, mr) {
+			v.checkRule(location, sourceRoot, targetRoot, source, mr, targets)
+			continue
+		}
+		expanded, missing, err := expandWildcard(sourceRoot, targetRoot, source, mr)
+		if err != nil {
+			v.report(location, "%v", err)
+			continue
+		}
+		for _, key := range missing {
+			v.report(location, "wildcard field %s has no matching field in target %s", key, mr.Target)
+		}
+		for _, s := range sortedRuleKeys(expanded) {
+			if _, ok := v.define.Mapper[s]; ok || explicit[expanded[s].Target] {
+				continue
+			}
+			v.checkRule(location, sourceRoot, targetRoot, s, expanded[s], targets)
+		}
+	}
+	return targets
+}
+
+//checkRule 检查mapper中的一条规则，并将目标路径记录到targets中
+func (v *validator) checkRule(location []string, sourceRoot, targetRoot *complexType, source string, mr *MapperRule, targets map[string]string) {
+	r, err := compileRule(source, mr)
+	if err != nil {
+		v.report(location, "%v", err)
+		return
+	}
+
+	sourceField, sourceDepth := v.checkSourcePath(location, sourceRoot, nil, r.source, r.sourcePaths)
+	targetField, targetDepth := v.checkTargetPath(location, targetRoot, r)
+
+	for _, x := range r.exprs() {
+		v.checkRefs(location, sourceRoot, x)
+	}
+	v.checkLookups(location, r)
+	if sourceField != nil && targetField != nil {
+		sourceDepth = v.checkPivot(location, r, sourceField, targetField, sourceDepth)
+		// 复杂类型之间按照字段名复制
+		if (targetField.spec.IsComplex() || targetField.spec.IsOneOf()) && r.pivot == nil && r.unpivot == nil &&
+			sourceField.complex == nil && !sourceField.spec.IsMap() && !sourceField.spec.IsAny() {
+			v.report(location, "target path %s is a complex field but source %s is not", r.target, r.source)
+		}
+		if targetField.complex != nil && !targetField.spec.IsMap() && r.pivot == nil && r.unpivot == nil {
+			v.copyFields(sourceField, targetField.complex, segmentsPath(r.targetPaths))
+		}
+	}
+
+	// 带有条件的多条规则可以写入同一个目标
+	if other, ok := targets[r.target]; ok && !(r.when != nil && v.conditional(other)) {
+		v.report(location, "target path %s is already mapped from %s", r.target, other)
+	} else {
+		targets[r.target] = r.source
+	}
+
+	// 单个值可以写入数组中的每一个元素，但源数据的数组层级不能多于目标，经过筛选的数组不计入层级
+	// any类型的目标原样保留整个数组
+	if sourceField != nil && targetField != nil && sourceDepth > targetDepth && !targetField.spec.IsAny() {
+		v.report(location, "array depth mismatch: source %s has %d array levels but target %s has %d",
+			r.source, sourceDepth, r.target, targetDepth)
+	}
+	if mr != nil && len(mr.Rename) > 0 && !isWildcard(source, mr) {
+		v.report(location, "rename is only supported on wildcard mappings")
+	}
+}
+
+//checkComputed 检查计算字段的表达式与目标路径，targets为mapper中已经映射的目标路径
+func (v *validator) checkComputed(targets map[string]string) {
+	sourceRoot := v.compiler.source()
+	targetRoot := v.compiler.root(v.define.Target)
+	for _, target := range sortedStringKeys(v.define.Computed) {
+		location := []string{"computed", target}
+		r, err := compileComputed(target, v.define.Computed[target])
+		if err != nil {
+			v.report(location, "%v", err)
+			continue
+		}
+
+		v.checkRefs(location, sourceRoot, r.expr)
+		v.checkLookups(location, r)
+		v.checkTargetPath(location, targetRoot, r)
+		if name := volatileFunc(r.expr); name != "" && sourceRoot.exprDepth(r.expr) > 0 {
+			v.report(location, "function %s cannot be combined with array paths, it would generate one value for all elements", name)
+		}
+		if other, ok := targets[r.target]; ok {
+			v.report(location, "target path %s is already mapped from %s", r.target, other)
+		} else {
+			targets[r.target] = r.source
+		}
+	}
+}
+
+//checkPassthrough 检查复制规则中include与exclude的路径
+func (v *validator) checkPassthrough() {
+	pr := v.define.Passthrough
+	if pr == nil {
+		return
+	}
+	for kind, paths := range [][]string{pr.Include, pr.Exclude} {
+		for i, path := range paths {
+			if _, err := splitTargetPath(path); err != nil {
+				v.report([]string{"passthrough", []string{"include", "exclude"}[kind], strconv.Itoa(i)}, "%v", err)
+			}
+		}
+	}
+}
+
+//checkConstants 检查常量的目标路径，并按照目标的规格转换常量的值
+func (v *validator) checkConstants(targets map[string]string) {
+	targetRoot := v.compiler.root(v.define.Target)
+	for _, target := range sortedMapKeys(v.define.Constants) {
+		location := []string{"constants", target}
+		r, err := compileConstant(target, v.define.Constants[target])
+		if err != nil {
+			v.report(location, "%v", err)
+			continue
+		}
+		f, _ := v.checkTargetPath(location, targetRoot, r)
+		if other, ok := targets[r.target]; ok {
+			v.report(location, "target path %s is already mapped from %s", r.target, other)
+		} else {
+			targets[r.target] = r.location()
+		}
+		if f == nil {
+			continue
+		}
+		// 与映射的值使用相同的转换，收集转换时产生的错误
+		m := newMapping(WithMultiError())
+		m.written = make(map[string]bool)
+		value, _ := r.expr.eval(nil)
+		var slot interface{}
+		m.setLeaf(&slot, f, value, r)
+		for _, err := range m.errs {
+			v.report(location, "%v", err)
+		}
+	}
+}
+
+//checkRefs 检查表达式中引用的源路径是否存在
+func (v *validator) checkRefs(location []string, sourceRoot *complexType, x expr) {
+	if x == nil {
+		return
+	}
+	for _, ref := range pathRefs(x) {
+		v.checkSourcePath(location, sourceRoot, nil, ref.text, ref.segs)
+	}
+}
+
+//checkSourcePath 检查源路径以及其中的筛选条件，scope不为nil时路径优先相对于scope字段的元素查找
+//返回路径末端的字段以及没有经过筛选的数组层级，路径无效时返回nil
+func (v *validator) checkSourcePath(location []string, sourceRoot *complexType, scope *field, text string, segs []segment) (*field, int) {
+	keys := segmentKeys(segs)
+	fields := walkFields(sourceRoot, nil, keys)
+	f, depth, problem := pathField(fields, keys)
+	if scope != nil {
+		if sfs := walkFields(nil, elementField(scope), keys); len(sfs) == len(keys) || problem != "" {
+			fields = sfs
+			f, depth, problem = pathField(fields, keys)
+		}
+	}
+	if problem != "" {
+		v.report(location, "source path %s: %s", text, problem)
+		return nil, 0
+	}
+
+	for i, seg := range segs {
+		sf := fields[i]
+		if seg.filter != nil {
+			if sf.complex == nil {
+				v.report(location, "source path %s: filter on %s requires a complex field", text, seg.key)
+			} else {
+				for _, ref := range pathRefs(seg.filter) {
+					v.checkSourcePath(location, sourceRoot, sf, ref.text, ref.segs)
+				}
+			}
+		}
+		if !v.checkIndex(location, "source", text, seg, sf) {
+			depth--
+		} else if seg.filter != nil && sf.spec.IsArray() {
+			depth--
+		}
+	}
+	return f, depth
+}
+
+//elementField 返回数组字段中单个元素对应的字段，用于查找筛选条件中相对于元素的路径
+func elementField(f *field) *field {
+	elem := *f
+	elem.spec.Multiple = "false"
+	return &elem
+}
+
+//checkTargetPath 检查规则的目标路径，返回路径末端的字段以及没有经过下标的数组层级，路径无效时返回nil
+func (v *validator) checkTargetPath(location []string, targetRoot *complexType, r *rule) (*field, int) {
+	keys := segmentKeys(r.targetPaths)
+	fields := walkFields(targetRoot, nil, keys)
+	f, depth, problem := pathField(fields, keys)
+	if problem == "" && len(fields) > 1 {
+		switch parent := fields[len(fields)-2]; {
+		case parent.spec.IsMap():
+			problem = "can't write into the keys of a map field"
+		case parent.spec.IsAny():
+			problem = "can't write into the fields of an any field"
+		}
+	}
+	if problem != "" {
+		v.report(location, "target path %s: %s", r.target, problem)
+		return nil, 0
+	}
+	switch {
+	case r.pivot != nil:
+		if !f.spec.IsMap() {
+			v.report(location, "pivot target %s is not a map field", r.target)
+		}
+	case r.unpivot != nil:
+		if !f.spec.IsComplex() || !f.spec.IsArray() {
+			v.report(location, "unpivot target %s is not a complex array field", r.target)
+		}
+	case !f.spec.IsSimple() && !f.spec.IsMap() && !f.spec.IsComplex() && !f.spec.IsOneOf() && !f.spec.IsAny():
+		v.report(location, "target path %s is not a simple field", r.target)
+	}
+	for i, seg := range r.targetPaths {
+		if !v.checkIndex(location, "target", r.target, seg, fields[i]) {
+			depth--
+		}
+	}
+	return f, depth
+}
+
+//checkPivot 检查行列转换规则中源数据与目标数据的结构，返回转换后源数据的数组层级
+//pivot将源数据中的一层数组转换为对象，unpivot将对象转换为目标中的一层数组
+func (v *validator) checkPivot(location []string, r *rule, sourceField, targetField *field, depth int) int {
+	switch {
+	case r.pivot != nil:
+		if sourceField.complex == nil {
+			v.report(location, "pivot source %s is not a complex field", r.source)
+			return depth
+		}
+		for _, segs := range [][]segment{r.pivot.key, r.pivot.value} {
+			if _, _, problem := walkPath(sourceField.complex, segmentKeys(segs)); problem != "" {
+				v.report(location, "pivot path %s: %s", segmentsPath(segs), problem)
+			}
+		}
+		if sourceField.spec.IsArray() {
+			depth--
+		}
+	case r.unpivot != nil:
+		if !sourceField.spec.IsMap() && (sourceField.complex == nil || sourceField.spec.IsArray()) {
+			v.report(location, "unpivot source %s is not a map or a complex field", r.source)
+		}
+		if targetField.complex == nil {
+			return depth
+		}
+		for _, segs := range [][]segment{r.unpivot.key, r.unpivot.value} {
+			f, _, problem := walkPath(targetField.complex, segmentKeys(segs))
+			if problem == "" && !f.spec.IsSimple() {
+				problem = "not a simple field"
+			}
+			if problem != "" {
+				v.report(location, "unpivot path %s: %s", segmentsPath(segs), problem)
+			}
+		}
+		depth++
+	}
+	return depth
+}
+
+//checkIndex 检查路径中的下标是否用在数组字段上，返回false表示该段通过下标取出了单个元素
+func (v *validator) checkIndex(location []string, kind, text string, seg segment, f *field) bool {
+	if seg.index == nil {
+		return true
+	}
+	if !f.spec.IsArray() {
+		v.report(location, "%s path %s: index on %s requires an array field", kind, text, seg.key)
+		return true
+	}
+	return seg.index.slice
+}
+
+//checkLookups 检查规则中引用的转换表是否已经声明
+func (v *validator) checkLookups(location []string, r *rule) {
+	for _, name := range lookupNames(r) {
+		if _, ok := v.define.Lookups[name]; !ok {
+			v.report(location, "lookup table %s is not defined", name)
+		}
+	}
+}
+
+//conditional 判断mapper中source对应的规则是否带有条件
+func (v *validator) conditional(source string) bool {
+	mr, ok := v.define.Mapper[source]
+	return ok && mr != nil && mr.When != ""
+}
+
+//checkRequired 检查required的目标字段是否有对应的映射规则
+func (v *validator) checkRequired(targets map[string]string) {
+	mapped := make(map[string]bool, len(targets))
+	for target := range targets {
+		if segs, err := splitPath(target); err == nil {
+			mapped[segmentsPath(segs)] = true
+		}
+	}
+	for _, path := range requiredPaths(v.compiler.root(v.define.Target)) {
+		if !mapped[path] && !v.copied[path] {
+			v.report(append([]string{"target"}, strings.Split(path, ".")...), "required target field %s is never mapped", path)
+		}
+	}
+}
+
+//copyFields 记录复杂类型整体复制时写入的子字段，target中与source同名的字段会被写入
+//source为map或any时字段由数据决定，target中的所有字段都视为已写入
+func (v *validator) copyFields(source *field, target *complexType, path string) {
+	seen := make(map[*complexType]bool)
+	var walk func(source *field, target *complexType, path string)
+	walk = func(source *field, target *complexType, path string) {
+		// 自引用的类型只展开一次，与requiredPaths相同
+		if seen[target] {
+			return
+		}
+		seen[target] = true
+		for _, key := range target.keys {
+			var sf *field
+			if source.complex != nil {
+				if sf = source.complex.fields[key]; sf == nil {
+					continue
+				}
+			} else if !source.spec.IsMap() && !source.spec.IsAny() {
+				continue
+			}
+			childPath := joinPath(path, key)
+			v.copied[childPath] = true
+			if tf := target.fields[key]; tf.complex != nil && !tf.spec.IsMap() {
+				if sf == nil {
+					sf = source
+				}
+				walk(sf, tf.complex, childPath)
+			}
+		}
+		delete(seen, target)
+	}
+	walk(source, target, path)
+}
+
+//walkPath 沿路径查找字段并统计经过的数组层级，路径无效时返回问题描述
+func walkPath(ct *complexType, paths []string) (*field, int, string) {
+	return pathField(walkFields(ct, nil, paths), paths)
+}
+
+//pathField 根据walkFields的结果返回路径末端的字段与经过的数组层级，路径无效时返回问题描述
+func pathField(fields []*field, paths []string) (*field, int, string) {
+	if len(paths) == 0 {
+		return nil, 0, "path is empty"
+	}
+	if n := len(fields); n < len(paths) {
+		if n > 0 && fields[n-1].complex == nil && !fields[n-1].spec.IsMap() {
+			return nil, 0, fmt.Sprintf("field %s is not a complex field", strings.Join(paths[:n], "."))
+		}
+		return nil, 0, fmt.Sprintf("field %s is not defined", strings.Join(paths[:n+1], "."))
+	}
+	depth := 0
+	for _, f := range fields {
+		if f.spec.IsArray() {
+			depth++
+		}
+	}
+	return fields[len(fields)-1], depth, ""
+}
+
+func (v *validator) report(location []string, format string, args ...interface{}) {
+	v.problems = append(v.problems, &SpecError{
+		Path:    strings.Join(location, "."),
+		Line:    v.line(location),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+//line 在yaml文档中查找location对应的行号，找不到时返回最近的上层节点的行号
+func (v *validator) line(location []string) int {
+	node, line := v.root, 0
+	for _, key := range location {
+		if node == nil || node.Kind != yamlv3.MappingNode {
+			break
+		}
+		var next *yamlv3.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				line = node.Content[i].Line
+				next = node.Content[i+1]
+				break
+			}
+		}
+		node = next
+	}
+	return line
+}
+
+//isFlag 判断规格中的开关取值是否合法，未填写视为false
+func isFlag(value string) bool {
+	return value == "" || value == "true" || value == "false"
+}
+
+func sortedRuleKeys(m map[string]*MapperRule) []string {
+	res := make([]string, 0, len(m))
+	for key := range m {
+		res = append(res, key)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func sortedKeys(m map[string]ComplexDefine) []string {
+	res := make([]string, 0, len(m))
+	for key := range m {
+		res = append(res, key)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	res := make([]string, 0, len(m))
+	for key := range m {
+		res = append(res, key)
+	}
+	sort.Strings(res)
+	return res
+}
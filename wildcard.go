@@ -0,0 +1,119 @@
+package datamapper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//isWildcard 判断mapper中的规则是否为复杂类型之间的通配符映射，例如 data.*: va.*
+func isWildcard(source string, mr *MapperRule) bool {
+	return mr != nil && strings.HasSuffix(source, "."+wildcardKey) && strings.HasSuffix(mr.Target, "."+wildcardKey)
+}
+
+//expandWildcard 将通配符规则展开为源对象中每一个字段的规则，字段名依次经过rename中的函数得到目标字段名
+//返回展开后的规则以及在目标中找不到对应字段的源字段
+func expandWildcard(sourceRoot, targetRoot *complexType, source string, mr *MapperRule) (map[string]*MapperRule, []string, error) {
+	sourcePrefix := strings.TrimSuffix(source, "."+wildcardKey)
+	targetPrefix := strings.TrimSuffix(mr.Target, "."+wildcardKey)
+	sourceSegs, err := splitPath(sourcePrefix)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mapper %s: %v", source, err)
+	}
+	targetSegs, err := splitTargetPath(targetPrefix)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mapper %s: %v", source, err)
+	}
+	sf := objectField(sourceRoot, sourceSegs)
+	if sf == nil {
+		return nil, nil, fmt.Errorf("mapper %s: wildcard source %s is not a complex field", source, sourcePrefix)
+	}
+	tf := objectField(targetRoot, targetSegs)
+	if tf == nil {
+		return nil, nil, fmt.Errorf("mapper %s: wildcard target %s is not a complex field", source, targetPrefix)
+	}
+	renames := make([]*step, 0, len(mr.Rename))
+	for _, text := range mr.Rename {
+		s, err := parseStep(text)
+		if err != nil {
+			return nil, nil, fmt.Errorf("mapper %s: rename: %v", source, err)
+		}
+		renames = append(renames, s)
+	}
+
+	e := &env{ctx: &FuncContext{SourcePath: source, TargetPath: mr.Target}}
+	res := make(map[string]*MapperRule, len(sf.complex.keys))
+	var missing []string
+	for _, key := range sf.complex.keys {
+		var name interface{} = key
+		for _, s := range renames {
+			if name, err = s.call(name, e); err != nil {
+				return nil, nil, fmt.Errorf("mapper %s: rename %s: %v", source, key, err)
+			}
+		}
+		if _, ok := tf.complex.fields[str(name)]; !ok {
+			missing = append(missing, key)
+			continue
+		}
+		er := *mr
+		er.Target = targetPrefix + "." + str(name)
+		er.Rename = nil
+		res[sourcePrefix+"."+key] = &er
+	}
+	return res, missing, nil
+}
+
+//objectField 返回路径指向的复杂类型字段，不是复杂类型或者是map时返回nil
+func objectField(root *complexType, segs []segment) *field {
+	keys := segmentKeys(segs)
+	fields := walkFields(root, nil, keys)
+	if len(fields) != len(keys) {
+		return nil
+	}
+	f := fields[len(fields)-1]
+	if f.complex == nil || f.spec.IsMap() {
+		return nil
+	}
+	return f
+}
+
+//mapper 返回展开通配符之后的mapper规则，显式声明的规则优先于通配符展开的规则
+//存在无法展开的通配符时返回第一个错误，其余的规则仍然会被返回
+func (c *compiler) mapper() (map[string]*MapperRule, error) {
+	res := make(map[string]*MapperRule, len(c.define.Mapper))
+	explicit := make(map[string]bool, len(c.define.Mapper))
+	var wildcards []string
+	for source, mr := range c.define.Mapper {
+		if isWildcard(source, mr) {
+			wildcards = append(wildcards, source)
+			continue
+		}
+		res[source] = mr
+		if mr != nil {
+			explicit[mr.Target] = true
+		}
+	}
+	if len(wildcards) == 0 {
+		return res, nil
+	}
+	sort.Strings(wildcards)
+
+	sourceRoot, targetRoot := c.source(), c.root(c.define.Target)
+	var first error
+	for _, source := range wildcards {
+		expanded, _, err := expandWildcard(sourceRoot, targetRoot, source, c.define.Mapper[source])
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		for s, er := range expanded {
+			if _, ok := res[s]; ok || explicit[er.Target] {
+				continue
+			}
+			res[s] = er
+		}
+	}
+	return res, first
+}